@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// apiRootPaths are probed in order to find the root of the API. Ansible
+// Tower and AWX serve it under /api/, newer Ansible Automation Platform
+// releases serve it under /api/controller/ behind the gateway.
+var apiRootPaths = []string{"/api/", "/api/controller/"}
+
+// apiRoot discovers the API root once per run and rewrites the canonical
+// /api/v2/ paths used by the platform to it and back
+type apiRoot struct {
+	once   sync.Once
+	prefix string
+}
+
+// discover returns the prefix of the current API version, it falls back
+// to the canonical prefix when the API root can't be found
+func (a *apiRoot) discover(ctx context.Context, config *CatalogConfig, client *http.Client) string {
+	a.once.Do(func() {
+		a.prefix = defaultAPIPrefix
+		for _, path := range apiRootPaths {
+			prefix, err := probeAPIRoot(ctx, config, client, path)
+			if err != nil {
+				log.Infof("API root %s: %v", path, err)
+				continue
+			}
+			a.prefix = prefix
+			break
+		}
+		log.Infof("Using API prefix %s", a.prefix)
+	})
+	return a.prefix
+}
+
+// probeAPIRoot reads the current_version of the API root at path. A
+// gateway root lists the APIs it serves, the controller one is followed.
+func probeAPIRoot(ctx context.Context, config *CatalogConfig, client *http.Client, path string) (string, error) {
+	jsonBody, err := getAPIObject(ctx, config, client, path)
+	if err != nil {
+		return "", err
+	}
+	if version, ok := jsonBody["current_version"].(string); ok && version != "" {
+		return withTrailingSlash(version), nil
+	}
+	if apis, ok := jsonBody["apis"].(map[string]interface{}); ok {
+		if controller, ok := apis["controller"].(string); ok && controller != path {
+			return probeAPIRoot(ctx, config, client, controller)
+		}
+	}
+	return "", errors.New("response has no current_version")
+}
+
+// getAPIObject does a GET for a path on the Ansible Tower host
+func getAPIObject(ctx context.Context, config *CatalogConfig, client *http.Client, path string) (map[string]interface{}, error) {
+	u, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = path
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Authorization", "Bearer "+config.Token)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("GET " + u.String() + " Status " + resp.Status)
+	if !successHTTPCode(resp.StatusCode) {
+		return nil, errors.New("HTTP GET call failed with " + resp.Status)
+	}
+	return decodeJSON(body)
+}
+
+// towerPath rewrites a canonical path to the discovered API prefix
+func (w *WorkUnit) towerPath(path string) string {
+	if w.config.API == nil {
+		return path
+	}
+	return replacePrefix(path, defaultAPIPrefix, w.config.API.discover(w.context(), w.config, w.client))
+}
+
+// canonicalPath rewrites a path under the discovered API prefix back to
+// the canonical /api/v2/ used by the platform
+func (w *WorkUnit) canonicalPath(path string) string {
+	if w.config.API == nil {
+		return path
+	}
+	return replacePrefix(path, w.config.API.discover(w.context(), w.config, w.client), defaultAPIPrefix)
+}
+
+// linkKeys hold a link to an API path
+var linkKeys = []string{"next", "previous", "url"}
+
+// canonicalLinks rewrites the next, previous, url and related links of the
+// objects in the body, at any depth, back to the canonical /api/v2/ paths.
+// Other strings are left as is even if they start with the API prefix.
+func (w *WorkUnit) canonicalLinks(value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			switch {
+			case key == "related":
+				if related, ok := item.(map[string]interface{}); ok {
+					for name, link := range related {
+						related[name] = w.canonicalLink(link)
+					}
+					continue
+				}
+			case includes(key, linkKeys):
+				if _, ok := item.(string); ok {
+					v[key] = w.canonicalLink(item)
+					continue
+				}
+			}
+			w.canonicalLinks(item)
+		}
+	case []interface{}:
+		for _, item := range v {
+			w.canonicalLinks(item)
+		}
+	}
+}
+
+// canonicalLink rewrites a link or a list of links
+func (w *WorkUnit) canonicalLink(link interface{}) interface{} {
+	switch v := link.(type) {
+	case string:
+		return w.canonicalPath(v)
+	case []interface{}:
+		for i, item := range v {
+			if s, ok := item.(string); ok {
+				v[i] = w.canonicalPath(s)
+			}
+		}
+	}
+	return link
+}
+
+func replacePrefix(path string, from string, to string) string {
+	if from == to || !strings.HasPrefix(path, from) {
+		return path
+	}
+	return to + strings.TrimPrefix(path, from)
+}
+
+func withTrailingSlash(path string) string {
+	if strings.HasSuffix(path, "/") {
+		return path
+	}
+	return path + "/"
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const defaultAPIPrefix = "/api/v2/"
+
+// catalogObject describes an object type fetched by catalog_sync
+type catalogObject struct {
+	name    string
+	filter  string
+	surveys bool
+}
+
+// catalogObjects are fetched in this order, the templates that have
+// survey_enabled also get their survey spec fetched
+var catalogObjects = []catalogObject{
+	{
+		name:    "job_templates",
+		filter:  "results[].{id:id, type:type, url:url, name:name, description:description, created:created, modified:modified, playbook:playbook, inventory:inventory, project:project, survey_enabled:survey_enabled, ask_inventory_on_launch:ask_inventory_on_launch, ask_credential_on_launch:ask_credential_on_launch, ask_variables_on_launch:ask_variables_on_launch}",
+		surveys: true,
+	},
+	{
+		name:    "workflow_job_templates",
+		filter:  "results[].{id:id, type:type, url:url, name:name, description:description, created:created, modified:modified, inventory:inventory, organization:organization, survey_enabled:survey_enabled, ask_inventory_on_launch:ask_inventory_on_launch, ask_variables_on_launch:ask_variables_on_launch}",
+		surveys: true,
+	},
+	{
+		name:   "inventories",
+		filter: "results[].{id:id, type:type, url:url, name:name, description:description, created:created, modified:modified, kind:kind, host_filter:host_filter, organization:organization}",
+	},
+	{
+		name:   "credentials",
+		filter: "results[].{id:id, type:type, url:url, name:name, description:description, created:created, modified:modified, credential_type:credential_type, organization:organization}",
+	},
+	{
+		name:   "credential_types",
+		filter: "results[].{id:id, type:type, url:url, name:name, description:description, created:created, modified:modified, kind:kind, namespace:namespace}",
+	},
+}
+
+// surveyRef identifies a template that has a survey spec
+type surveyRef struct {
+	objectType string
+	id         string
+}
+
+// catalogSync fetches all the object types used by the catalog with their
+// default filters, streams the pages grouped by object type followed by
+// the survey specs and finishes with a manifest of what was sent.
+func (w *WorkUnit) catalogSync() error {
+	prefix := w.input.HrefSlug
+	if prefix == "" {
+		prefix = defaultAPIPrefix
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	manifest := make(map[string]interface{})
+	objectTypes := make([]string, 0, len(catalogObjects)+1)
+	var surveys []surveyRef
+
+	for _, object := range catalogObjects {
+		if err := w.context().Err(); err != nil {
+			log.Error(err)
+			return err
+		}
+		objectTypes = append(objectTypes, object.name)
+		count, pages := 0, 0
+		sub, err := w.subWork(JobParam{
+			Method:         "get",
+			HrefSlug:       prefix + object.name + "/",
+			FetchAllPages:  true,
+			AcceptEncoding: w.input.AcceptEncoding,
+			ApplyFilter:    object.filter,
+			Params:         copyParams(w.input.Params),
+		})
+		if err == nil {
+			objectType := object.name
+			err = sub.eachPage(func(jsonBody map[string]interface{}, status int) error {
+				results, _ := jsonBody["results"].([]interface{})
+				count += len(results)
+				pages++
+				if object.surveys {
+					surveys = append(surveys, surveyRefs(objectType, results)...)
+				}
+				jsonBody["object_type"] = objectType
+				return sub.writePage(jsonBody, status)
+			})
+		}
+		entry := map[string]interface{}{"count": count, "pages": pages}
+		if err != nil {
+			log.Error(err)
+			entry["error"] = err.Error()
+		}
+		manifest[object.name] = entry
+	}
+
+	objectTypes = append(objectTypes, "survey_spec")
+	count := 0
+	var failed []string
+	for _, survey := range surveys {
+		if err := w.context().Err(); err != nil {
+			log.Error(err)
+			return err
+		}
+		href := prefix + survey.objectType + "/" + survey.id + "/survey_spec/"
+		err := w.syncSurvey(href)
+		if err != nil {
+			log.Error(err)
+			failed = append(failed, href)
+			continue
+		}
+		count++
+	}
+	entry := map[string]interface{}{"count": count, "pages": count}
+	if len(failed) > 0 {
+		entry["failed"] = failed
+	}
+	manifest["survey_spec"] = entry
+
+	body := map[string]interface{}{"object_type": "manifest", "object_types": objectTypes, "manifest": manifest}
+	return w.writeObject(prefix, body, 200)
+}
+
+func (w *WorkUnit) syncSurvey(href string) error {
+	sub, err := w.subWork(JobParam{Method: "get", HrefSlug: href, AcceptEncoding: w.input.AcceptEncoding})
+	if err != nil {
+		return err
+	}
+	return sub.eachPage(func(jsonBody map[string]interface{}, status int) error {
+		jsonBody["object_type"] = "survey_spec"
+		return sub.writePage(jsonBody, status)
+	})
+}
+
+// surveyRefs returns the templates in the results that have survey_enabled
+func surveyRefs(objectType string, results []interface{}) []surveyRef {
+	var refs []surveyRef
+	for _, r := range results {
+		template, ok := r.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if enabled, _ := template["survey_enabled"].(bool); !enabled {
+			continue
+		}
+		if id, ok := template["id"].(json.Number); ok {
+			refs = append(refs, surveyRef{objectType: objectType, id: id.String()})
+		}
+	}
+	return refs
+}
+
+func copyParams(params map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(params))
+	for k, v := range params {
+		result[k] = v
+	}
+	return result
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// commands replay a request file locally, without one the worker reads
+// the request from the Receptor on stdin
+var commands = map[string]func(args []string, stdin io.Reader, stdout io.Writer) error{
+	"run":      runCommand,
+	"validate": validateCommand,
+}
+
+// runCommand runs the jobs in a request file against Ansible Tower and
+// prints the responses, decoded and indented when --pretty is given
+func runCommand(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	input := fs.String("input", "", "request file to run, stdin when empty")
+	pretty := fs.Bool("pretty", false, "decode and indent the responses")
+	config := CatalogConfig{}
+	err := parseConfig(fs, args, &config)
+	if err != nil {
+		return err
+	}
+	configLogger(&config, os.Stderr)
+
+	drh := &DefaultRequestHandler{Output: stdout}
+	req, err := readRequestFile(drh, *input, stdin)
+	if err != nil {
+		return err
+	}
+
+	var pw *prettyWriter
+	if *pretty {
+		pw = &prettyWriter{Output: stdout}
+		drh.Output = pw
+	}
+	ctx, stop := notifyShutdown(context.Background(), config.ShutdownGracePeriod, drh.abort)
+	drh.processRequest(ctx, req, config, &DefaultAPIWorker{})
+	stop()
+	if pw != nil {
+		return pw.Flush()
+	}
+	return nil
+}
+
+// validateCommand parses a request file and checks it without running it
+func validateCommand(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	input := fs.String("input", "", "request file to validate, stdin when empty")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	req, err := readRequestFile(&DefaultRequestHandler{}, *input, stdin)
+	if err != nil {
+		return err
+	}
+	errs := validateRequest(req)
+	for _, err := range errs {
+		fmt.Fprintln(stdout, err)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("request has %d errors", len(errs))
+	}
+	fmt.Fprintf(stdout, "Request is valid, %d jobs\n", len(req.Payload.Jobs))
+	return nil
+}
+
+func readRequestFile(rh RequestHandler, fileName string, stdin io.Reader) (*RequestMessage, error) {
+	reader := stdin
+	if fileName != "" {
+		f, err := os.Open(fileName)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		reader = f
+	}
+	b, err := rh.getRequest(reader)
+	if err != nil {
+		return nil, err
+	}
+	return rh.parseRequest(b)
+}
+
+// prettyWriter formats each response line written by the Responder
+type prettyWriter struct {
+	Output io.Writer
+	buf    bytes.Buffer
+}
+
+func (p *prettyWriter) Write(b []byte) (int, error) {
+	p.buf.Write(b)
+	for {
+		i := bytes.IndexByte(p.buf.Bytes(), '\n')
+		if i < 0 {
+			return len(b), nil
+		}
+		line := p.buf.Next(i + 1)
+		err := p.writeLine(line[:i])
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Flush writes a last line that didn't end with a new line
+func (p *prettyWriter) Flush() error {
+	if p.buf.Len() == 0 {
+		return nil
+	}
+	return p.writeLine(p.buf.Next(p.buf.Len()))
+}
+
+func (p *prettyWriter) writeLine(line []byte) error {
+	s, err := formatResponse(line)
+	if err != nil {
+		_, err = fmt.Fprintf(p.Output, "%s\n", line)
+		return err
+	}
+	_, err = io.WriteString(p.Output, s)
+	return err
+}
+
+// formatResponse turns a response line into a header with the serial,
+// type, status and href_slug followed by the decoded body
+func formatResponse(line []byte) (string, error) {
+	var resp ResponseMessage
+	err := json.Unmarshal(line, &resp)
+	if err != nil {
+		return "", err
+	}
+
+	kind := resp.MessageType
+	if kind == "data" && resp.Code != 0 {
+		kind = "error"
+	}
+	header := fmt.Sprintf("[%d] %s", resp.Serial, kind)
+	if resp.Payload.Status != 0 {
+		header += fmt.Sprintf(" %d", resp.Payload.Status)
+	}
+	if resp.Payload.HrefSlug != "" {
+		header += " " + resp.Payload.HrefSlug
+	}
+
+	body, err := decodeBody(resp.Payload)
+	if err != nil {
+		return "", err
+	}
+	if len(body) == 0 {
+		return header + "\n", nil
+	}
+	var indented bytes.Buffer
+	if json.Indent(&indented, body, "", "  ") == nil {
+		body = indented.Bytes()
+	}
+	return header + "\n" + string(body) + "\n", nil
+}
+
+// decodeBody undoes the gzip and base64 encoding of a response body
+func decodeBody(rd ResponseData) ([]byte, error) {
+	if rd.Encoding != "gzip" {
+		return []byte(rd.Body), nil
+	}
+	data, err := base64.StdEncoding.DecodeString(rd.Body)
+	if err != nil {
+		return nil, errors.New("Error decoding base64 body: " + err.Error())
+	}
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
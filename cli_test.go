@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunCommandPretty(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v2/job_templates/":
+			fmt.Fprint(w, `{"count": 1, "previous": null, "next": null, "results": [{"id": 1, "name": "jt1"}]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	input := strings.NewReader(`{"account":"12345","sender":"buzz", "message_id":"4567","payload":{"jobs": [{"method":"get","href_slug":"/api/v2/job_templates/","accept_encoding":"gzip"}]}}`)
+	var out bytes.Buffer
+	err := runCommand([]string{"--token", "123", "--url", server.URL, "--pretty"}, input, &out)
+	if err != nil {
+		t.Fatalf("run failed %v", err)
+	}
+	s := out.String()
+	if !strings.Contains(s, "[1] data 200 /api/v2/job_templates/\n{\n") || !strings.Contains(s, `"name": "jt1"`) {
+		t.Errorf("Response has not been decoded %s", s)
+	}
+	if !strings.Contains(s, "] eof\n") {
+		t.Errorf("Missing eof %s", s)
+	}
+}
+
+func TestValidateCommand(t *testing.T) {
+	var out bytes.Buffer
+	err := validateCommand([]string{"--input", "sample_inputs/multiples"}, nil, &out)
+	if err != nil {
+		t.Fatalf("validate failed %v %s", err, out.String())
+	}
+	if !strings.Contains(out.String(), "Request is valid, 3 jobs") {
+		t.Errorf("Output didn't match %s", out.String())
+	}
+}
+
+func TestValidateCommandInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "catalog_worker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "request")
+	err = ioutil.WriteFile(fileName, []byte(`{"account":"12345","sender":"buzz", "message_id":"4567","payload":{"jobs": [{"method":"delete","href_slug":"/api/v2/jobs/1"},{"method":"get"}]}}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	err = validateCommand([]string{"--input", fileName}, nil, &out)
+	if err == nil {
+		t.Fatalf("validate should have failed")
+	}
+	if !strings.Contains(out.String(), "job 1: method delete should be one of") || !strings.Contains(out.String(), "job 2: href_slug is required") {
+		t.Errorf("Output didn't match %s", out.String())
+	}
+}
+
+func TestFormatResponseError(t *testing.T) {
+	s, err := formatResponse([]byte(`{"message_type":"data","payload":{"href_slug":"/api/v2/jobs/1","body":"Not Found","status":404},"code":1,"serial":2}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s != "[2] error 404 /api/v2/jobs/1\nNot Found\n" {
+		t.Errorf("Formatted response didn't match %q", s)
+	}
+}
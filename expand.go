@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/mkanoor/catalog_worker/internal/filters"
+	log "github.com/sirupsen/logrus"
+)
+
+// expandCache holds the related objects fetched by expand, it is shared
+// by the jobs of a request so a link is only fetched once per request
+type expandCache struct {
+	mu      sync.Mutex
+	objects map[string]map[string]interface{}
+}
+
+func (c *expandCache) get(href string) (map[string]interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	object, ok := c.objects[href]
+	return object, ok
+}
+
+func (c *expandCache) put(href string, object map[string]interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.objects == nil {
+		c.objects = make(map[string]map[string]interface{})
+	}
+	c.objects[href] = object
+}
+
+// expand embeds the objects linked in the related attribute for each of
+// the keys in the expand list. The objects go into an expanded attribute
+// next to related, a link that can't be fetched has an error in its place.
+func (w *WorkUnit) expand(jsonBody map[string]interface{}) {
+	cache := w.config.Expanded
+	if cache == nil {
+		cache = &expandCache{}
+	}
+	filters.EachObject(jsonBody, func(object map[string]interface{}) {
+		related, ok := object["related"].(map[string]interface{})
+		if !ok {
+			return
+		}
+		expanded := make(map[string]interface{}, len(w.input.Expand))
+		for _, key := range w.input.Expand {
+			href, ok := related[key].(string)
+			if !ok {
+				expanded[key] = nil
+				continue
+			}
+			value, err := w.fetchRelated(cache, key, href)
+			if err != nil {
+				log.Error(err)
+				value = map[string]interface{}{"error": err.Error()}
+			}
+			expanded[key] = value
+		}
+		object["expanded"] = expanded
+	})
+}
+
+// fetchRelated gets the linked object and applies the filter for the
+// relation. The objects are cached before they are filtered since the
+// jobs sharing the cache can have different filters.
+func (w *WorkUnit) fetchRelated(cache *expandCache, key string, href string) (interface{}, error) {
+	object, ok := cache.get(href)
+	if !ok {
+		var err error
+		object, err = w.getRelated(href)
+		if err != nil {
+			return nil, err
+		}
+		cache.put(href, object)
+	}
+
+	jsonBody := deepCopy(object).(map[string]interface{})
+	if fltr, ok := w.expandFilters[key]; ok {
+		return fltr.Apply(jsonBody)
+	}
+	return jsonBody, nil
+}
+
+func (w *WorkUnit) getRelated(href string) (map[string]interface{}, error) {
+	sub, err := w.subWork(JobParam{Method: "get", HrefSlug: href})
+	if err != nil {
+		return nil, err
+	}
+	body, resp, err := sub.fetchPage()
+	if err != nil {
+		return nil, err
+	}
+	if !successHTTPCode(resp.StatusCode) {
+		return nil, fmt.Errorf("GET %s failed with %s", href, resp.Status)
+	}
+	return decodeJSON(body)
+}
+
+// deepCopy copies the maps and lists of a decoded JSON value so that
+// the same cached object can be embedded and changed in many places
+func deepCopy(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			result[key] = deepCopy(item)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = deepCopy(item)
+		}
+		return result
+	}
+	return value
+}
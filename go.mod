@@ -4,6 +4,7 @@ go 1.14
 
 require (
 	github.com/google/uuid v1.1.2
+	github.com/itchyny/gojq v0.12.1
 	github.com/jmespath/go-jmespath v0.3.0
 	github.com/sirupsen/logrus v1.6.0
 	github.com/smartystreets/goconvey v1.6.4 // indirect
@@ -3,6 +3,8 @@ package artifacts
 import (
 	"encoding/json"
 	"fmt"
+	"regexp"
+	"sort"
 	"strings"
 
 	log "github.com/sirupsen/logrus"
@@ -11,25 +13,234 @@ import (
 const ExposePrefix = "expose_to_cloud_redhat_com_"
 const MaxArtifactsBytes = 1024
 
-// Sanctify the JSON payload for artifacts. The attribute key in the artifacts
-// map should start with expose_to_cloud_redhat_com_ else they are excluded
+// The policies for artifacts that are larger than MaxBytes
+const (
+	PolicyFail     = "fail"     // Fail the response
+	PolicyDrop     = "drop"     // Drop all the artifacts
+	PolicyTruncate = "truncate" // Keep keys in order until MaxBytes is reached
+)
+
+// Report describes what was done with artifacts larger than MaxBytes
+type Report struct {
+	Policy      string   `json:"policy"`
+	DroppedKeys []string `json:"dropped_keys"`
+}
+
+// Rules decide which artifacts are exposed to the platform
+type Rules struct {
+	Prefixes    []string         // Keys starting with one of the prefixes are exposed
+	Patterns    []*regexp.Regexp // Keys matching one of the patterns are exposed
+	AllowKeys   []string         // Keys that are always exposed
+	MaxBytes    int              // Max size of the exposed artifacts as JSON
+	StripPrefix bool             // Remove the matching prefix from exposed keys
+	Policy      string           // What to do when the artifacts are larger than MaxBytes
+	Schema      *Schema          // Optional schema the exposed artifacts are validated against
+}
+
+// DefaultRules only exposes keys starting with expose_to_cloud_redhat_com_
+func DefaultRules() *Rules {
+	return &Rules{Prefixes: []string{ExposePrefix}, MaxBytes: MaxArtifactsBytes, Policy: PolicyFail}
+}
+
+// NewRules builds the Rules from the config values, the patterns
+// are compiled as regular expressions
+func NewRules(prefixes []string, patterns []string, keys []string, maxBytes int, stripPrefix bool) (*Rules, error) {
+	r := &Rules{Prefixes: prefixes, AllowKeys: keys, MaxBytes: maxBytes, StripPrefix: stripPrefix, Policy: PolicyFail}
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid artifact pattern %q: %v", p, err)
+		}
+		r.Patterns = append(r.Patterns, re)
+	}
+	return r, nil
+}
+
+// Sanctify the JSON payload for artifacts using the DefaultRules
 func Sanctify(data map[string]interface{}) (map[string]interface{}, error) {
+	return DefaultRules().Sanctify(data)
+}
+
+// SanctifyAll filters every artifacts attribute found in the body at any
+// depth, e.g. in the results of a list response or in workflow nodes. An
+// artifacts attribute that is not an object is replaced by an empty object.
+// When the oversized artifacts are dropped or truncated the Report is added
+// next to them as artifacts_report, and when there is a Schema the
+// validation errors are added as artifacts_errors.
+func (r *Rules) SanctifyAll(body interface{}) error {
+	switch v := body.(type) {
+	case map[string]interface{}:
+		for k, value := range v {
+			if k == "artifacts" {
+				err := r.sanctifyAttribute(v)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			err := r.SanctifyAll(value)
+			if err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, value := range v {
+			err := r.SanctifyAll(value)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *Rules) sanctifyAttribute(object map[string]interface{}) error {
+	switch data := object["artifacts"].(type) {
+	case nil:
+		return nil
+	case map[string]interface{}:
+		result, report, err := r.Filter(data)
+		if err != nil {
+			return err
+		}
+		object["artifacts"] = result
+		if report != nil {
+			object["artifacts_report"] = report
+		}
+		if r.Schema != nil {
+			if errs := r.Schema.Validate(result); len(errs) > 0 {
+				object["artifacts_errors"] = errs
+			}
+		}
+	default:
+		log.Warnf("Artifacts of type %T is not an object, replacing it with an empty object", data)
+		object["artifacts"] = make(map[string]interface{})
+	}
+	return nil
+}
+
+// WithSchema returns a copy of the rules that validates against the schema
+func (r *Rules) WithSchema(schema *Schema) *Rules {
+	rules := *r
+	rules.Schema = schema
+	return &rules
+}
+
+// SetPolicy validates and sets the policy for oversized artifacts
+func (r *Rules) SetPolicy(policy string) error {
+	switch policy {
+	case PolicyFail, PolicyDrop, PolicyTruncate:
+		r.Policy = policy
+		return nil
+	}
+	return fmt.Errorf("Invalid artifacts policy %q, should be one of fail, drop or truncate", policy)
+}
+
+// Sanctify the JSON payload for artifacts. Only the attributes allowed by
+// the rules are included and the result has to fit in MaxBytes
+func (r *Rules) Sanctify(data map[string]interface{}) (map[string]interface{}, error) {
+	result, _, err := r.Filter(data)
+	return result, err
+}
+
+// Filter the artifacts like Sanctify, when the artifacts are larger than
+// MaxBytes the Policy is applied and a Report of the dropped keys returned
+func (r *Rules) Filter(data map[string]interface{}) (map[string]interface{}, *Report, error) {
 	result := make(map[string]interface{})
-	for k, v := range data {
-		if strings.HasPrefix(k, ExposePrefix) {
-			result[k] = v
+	for _, k := range sortedKeys(data) {
+		if exposed, ok := r.exposedKey(k); ok {
+			if _, found := result[exposed]; !found {
+				result[exposed] = data[k]
+			}
 		}
 	}
 
 	b, err := json.Marshal(result)
 	if err != nil {
 		log.Println("Error marshaling to json error:", err)
-		return nil, err
+		return nil, nil, err
+	}
+
+	if len(b) <= r.MaxBytes {
+		return result, nil, nil
 	}
 
-	if len(b) > MaxArtifactsBytes {
-		err = fmt.Errorf("Artifacts is greater than %d bytes", MaxArtifactsBytes)
-		return nil, err
+	switch r.Policy {
+	case PolicyDrop:
+		report := &Report{Policy: PolicyDrop, DroppedKeys: sortedKeys(result)}
+		return make(map[string]interface{}), report, nil
+	case PolicyTruncate:
+		return r.truncate(result)
+	}
+	err = fmt.Errorf("Artifacts is greater than %d bytes", r.MaxBytes)
+	return nil, nil, err
+}
+
+// truncate keeps the keys in sorted order until the JSON encoding
+// of the artifacts would no longer fit in MaxBytes
+func (r *Rules) truncate(data map[string]interface{}) (map[string]interface{}, *Report, error) {
+	result := make(map[string]interface{})
+	report := &Report{Policy: PolicyTruncate, DroppedKeys: []string{}}
+	size := len("{}")
+	full := false
+	for _, k := range sortedKeys(data) {
+		if full {
+			report.DroppedKeys = append(report.DroppedKeys, k)
+			continue
+		}
+		key, err := json.Marshal(k)
+		if err != nil {
+			return nil, nil, err
+		}
+		value, err := json.Marshal(data[k])
+		if err != nil {
+			return nil, nil, err
+		}
+		// "key":value plus a comma separator after the first entry
+		entry := len(key) + 1 + len(value)
+		if len(result) > 0 {
+			entry++
+		}
+		if size+entry > r.MaxBytes {
+			report.DroppedKeys = append(report.DroppedKeys, k)
+			full = true
+			continue
+		}
+		result[k] = data[k]
+		size += entry
+	}
+	return result, report, nil
+}
+
+func sortedKeys(data map[string]interface{}) []string {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// exposedKey checks the key against the rules and returns the key
+// to use in the exposed artifacts
+func (r *Rules) exposedKey(k string) (string, bool) {
+	for _, key := range r.AllowKeys {
+		if k == key {
+			return k, true
+		}
+	}
+	for _, prefix := range r.Prefixes {
+		if strings.HasPrefix(k, prefix) {
+			if r.StripPrefix && len(k) > len(prefix) {
+				return strings.TrimPrefix(k, prefix), true
+			}
+			return k, true
+		}
+	}
+	for _, re := range r.Patterns {
+		if re.MatchString(k) {
+			return k, true
+		}
 	}
-	return result, nil
+	return "", false
 }
@@ -57,3 +57,192 @@ func TestNoArtifact(t *testing.T) {
 		t.Errorf("name key should not be included in artifact")
 	}
 }
+
+func TestStripPrefix(t *testing.T) {
+	r := DefaultRules()
+	r.StripPrefix = true
+	data := map[string]interface{}{
+		"expose_to_cloud_redhat_com_name": "Fred",
+		"abc":                             "123"}
+
+	result, err := r.Sanctify(data)
+	if err != nil {
+		t.Fatalf("Artifact test failed %v", err)
+	}
+	if result["name"].(string) != "Fred" {
+		t.Errorf("Prefix was not stripped from name")
+	}
+	if len(result) != 1 {
+		t.Errorf("Only name should be included in artifact")
+	}
+}
+
+func TestPatternsAndKeys(t *testing.T) {
+	r, err := NewRules([]string{"catalog_"}, []string{"^vm_[a-z]+$"}, []string{"hostname"}, 2048, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := map[string]interface{}{
+		"catalog_id":                      5,
+		"vm_ip":                           "10.0.0.1",
+		"vm_ip_6":                         "::1",
+		"hostname":                        "fred",
+		"expose_to_cloud_redhat_com_name": "Fred"}
+
+	result, err := r.Sanctify(data)
+	if err != nil {
+		t.Fatalf("Artifact test failed %v", err)
+	}
+	for _, k := range []string{"catalog_id", "vm_ip", "hostname"} {
+		if _, ok := result[k]; !ok {
+			t.Errorf("%s should be included in artifact", k)
+		}
+	}
+	for _, k := range []string{"vm_ip_6", "expose_to_cloud_redhat_com_name"} {
+		if _, ok := result[k]; ok {
+			t.Errorf("%s should not be included in artifact", k)
+		}
+	}
+}
+
+func TestMaxBytes(t *testing.T) {
+	r := DefaultRules()
+	r.MaxBytes = 4096
+	data := map[string]interface{}{
+		"expose_to_cloud_redhat_com_name": strings.Repeat("na", 1024)}
+
+	_, err := r.Sanctify(data)
+	if err != nil {
+		t.Errorf("Artifact should fit in 4096 bytes %v", err)
+	}
+}
+
+func TestBadPattern(t *testing.T) {
+	_, err := NewRules(nil, []string{"(unclosed"}, nil, 1024, false)
+	if err == nil {
+		t.Error("Invalid pattern did not fail")
+	}
+}
+
+func TestDropPolicy(t *testing.T) {
+	r := DefaultRules()
+	if err := r.SetPolicy(PolicyDrop); err != nil {
+		t.Fatal(err)
+	}
+	data := map[string]interface{}{
+		"expose_to_cloud_redhat_com_name": strings.Repeat("na", 512),
+		"expose_to_cloud_redhat_com_age":  45}
+
+	result, report, err := r.Filter(data)
+	if err != nil {
+		t.Fatalf("Drop policy should not fail %v", err)
+	}
+	if len(result) != 0 {
+		t.Errorf("All artifacts should be dropped")
+	}
+	if report == nil || report.Policy != PolicyDrop || len(report.DroppedKeys) != 2 {
+		t.Errorf("Report didn't match %v", report)
+	}
+}
+
+func TestTruncatePolicy(t *testing.T) {
+	r := DefaultRules()
+	if err := r.SetPolicy(PolicyTruncate); err != nil {
+		t.Fatal(err)
+	}
+	data := map[string]interface{}{
+		"expose_to_cloud_redhat_com_age":  45,
+		"expose_to_cloud_redhat_com_name": strings.Repeat("na", 512),
+		"expose_to_cloud_redhat_com_zip":  "12345"}
+
+	result, report, err := r.Filter(data)
+	if err != nil {
+		t.Fatalf("Truncate policy should not fail %v", err)
+	}
+	if result["expose_to_cloud_redhat_com_age"].(int) != 45 {
+		t.Errorf("age should be kept")
+	}
+	if len(result) != 1 {
+		t.Errorf("Keys after the budget is reached should be dropped %v", result)
+	}
+	expected := []string{"expose_to_cloud_redhat_com_name", "expose_to_cloud_redhat_com_zip"}
+	if report == nil || report.Policy != PolicyTruncate || strings.Join(report.DroppedKeys, ",") != strings.Join(expected, ",") {
+		t.Errorf("Report didn't match %v", report)
+	}
+}
+
+func TestNoReportWhenSmall(t *testing.T) {
+	r := DefaultRules()
+	if err := r.SetPolicy(PolicyTruncate); err != nil {
+		t.Fatal(err)
+	}
+	_, report, err := r.Filter(map[string]interface{}{"expose_to_cloud_redhat_com_age": 45})
+	if err != nil || report != nil {
+		t.Errorf("Small artifacts should not be reported %v %v", report, err)
+	}
+}
+
+func TestBadPolicy(t *testing.T) {
+	r := DefaultRules()
+	if err := r.SetPolicy("shrink"); err == nil {
+		t.Error("Invalid policy did not fail")
+	}
+}
+
+func TestSanctifyAllNested(t *testing.T) {
+	body := map[string]interface{}{
+		"count": 2,
+		"results": []interface{}{
+			map[string]interface{}{"id": 1, "artifacts": map[string]interface{}{"abc": "123", "expose_to_cloud_redhat_com_name": "Fred"}},
+			map[string]interface{}{"id": 2, "summary_fields": map[string]interface{}{
+				"job": map[string]interface{}{"artifacts": map[string]interface{}{"secret_key": "xyz"}}}},
+		},
+	}
+
+	err := DefaultRules().SanctifyAll(body)
+	if err != nil {
+		t.Fatalf("Artifact test failed %v", err)
+	}
+	results := body["results"].([]interface{})
+	first := results[0].(map[string]interface{})["artifacts"].(map[string]interface{})
+	if _, ok := first["abc"]; ok || first["expose_to_cloud_redhat_com_name"] != "Fred" {
+		t.Errorf("Artifacts in results were not filtered %v", first)
+	}
+	job := results[1].(map[string]interface{})["summary_fields"].(map[string]interface{})["job"].(map[string]interface{})
+	if len(job["artifacts"].(map[string]interface{})) != 0 {
+		t.Errorf("Nested artifacts were not filtered %v", job)
+	}
+}
+
+func TestSanctifyAllNotMap(t *testing.T) {
+	body := map[string]interface{}{"id": 1, "artifacts": "expose_to_cloud_redhat_com_name=Fred"}
+	err := DefaultRules().SanctifyAll(body)
+	if err != nil {
+		t.Fatalf("Artifact test failed %v", err)
+	}
+	if len(body["artifacts"].(map[string]interface{})) != 0 {
+		t.Errorf("Artifacts should be replaced with an empty object")
+	}
+}
+
+func TestSanctifyAllReport(t *testing.T) {
+	r := DefaultRules()
+	r.Policy = PolicyDrop
+	node := map[string]interface{}{"artifacts": map[string]interface{}{"expose_to_cloud_redhat_com_name": strings.Repeat("na", 512)}}
+	body := map[string]interface{}{"results": []interface{}{node}}
+	err := r.SanctifyAll(body)
+	if err != nil {
+		t.Fatalf("Artifact test failed %v", err)
+	}
+	if _, ok := node["artifacts_report"]; !ok {
+		t.Error("Report should be added next to the artifacts")
+	}
+}
+
+func TestSanctifyAllTooLarge(t *testing.T) {
+	body := []interface{}{map[string]interface{}{"artifacts": map[string]interface{}{"expose_to_cloud_redhat_com_name": strings.Repeat("na", 512)}}}
+	err := DefaultRules().SanctifyAll(body)
+	if err == nil {
+		t.Error("Oversized nested artifacts did not fail")
+	}
+}
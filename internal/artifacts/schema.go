@@ -0,0 +1,330 @@
+package artifacts
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schema is the subset of JSON Schema used to validate the exposed
+// artifacts. The top level schema describes the artifacts object.
+type Schema struct {
+	Type       string             `json:"type"`
+	Format     string             `json:"format"`
+	Enum       []interface{}      `json:"enum"`
+	Pattern    string             `json:"pattern"`
+	Minimum    *float64           `json:"minimum"`
+	Maximum    *float64           `json:"maximum"`
+	MinLength  *int               `json:"minLength"`
+	MaxLength  *int               `json:"maxLength"`
+	Properties map[string]*Schema `json:"properties"`
+	Required   []string           `json:"required"`
+	Items      *Schema            `json:"items"`
+	pattern    *regexp.Regexp
+}
+
+var schemaTypes = []string{"", "string", "integer", "number", "boolean", "array", "object"}
+var schemaFormats = []string{"", "ip", "ipv4", "ipv6", "uri", "date-time"}
+
+// ParseSchema parses a decoded JSON Schema document
+func ParseSchema(data interface{}) (*Schema, error) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	s := &Schema{}
+	err = json.Unmarshal(b, s)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid artifacts schema: %v", err)
+	}
+	if s.Type == "" && s.Properties != nil {
+		s.Type = "object"
+	}
+	if s.Type != "object" {
+		return nil, errors.New("Invalid artifacts schema: the top level type must be object")
+	}
+	err = s.compile("")
+	if err != nil {
+		return nil, fmt.Errorf("Invalid artifacts schema: %v", err)
+	}
+	return s, nil
+}
+
+func (s *Schema) compile(path string) error {
+	if !contains(schemaTypes, s.Type) {
+		return fmt.Errorf("%sunsupported type %q", prefix(path), s.Type)
+	}
+	if !contains(schemaFormats, s.Format) {
+		return fmt.Errorf("%sunsupported format %q", prefix(path), s.Format)
+	}
+	if s.Pattern != "" {
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("%sinvalid pattern: %v", prefix(path), err)
+		}
+		s.pattern = re
+	}
+	for k, p := range s.Properties {
+		if p == nil {
+			return fmt.Errorf("%sproperty %s has no schema", prefix(path), k)
+		}
+		err := p.compile(join(path, k))
+		if err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		return s.Items.compile(path + "[]")
+	}
+	return nil
+}
+
+// Validate the artifacts against the schema. Values are coerced to the
+// declared types, values that are invalid are removed from the artifacts
+// and the errors are returned by key.
+func (s *Schema) Validate(artifacts map[string]interface{}) map[string]string {
+	errs := make(map[string]string)
+	for _, k := range s.Required {
+		if _, ok := artifacts[k]; !ok {
+			errs[k] = "is required"
+		}
+	}
+	for k, value := range artifacts {
+		p, ok := s.Properties[k]
+		if !ok {
+			continue
+		}
+		coerced, err := p.coerce(value)
+		if err != nil {
+			errs[k] = err.Error()
+			delete(artifacts, k)
+			continue
+		}
+		artifacts[k] = coerced
+	}
+	return errs
+}
+
+func (s *Schema) coerce(value interface{}) (interface{}, error) {
+	var err error
+	switch s.Type {
+	case "string":
+		value, err = toString(value)
+	case "integer":
+		value, err = toInteger(value)
+	case "number":
+		value, err = toNumber(value)
+	case "boolean":
+		value, err = toBoolean(value)
+	case "array":
+		value, err = s.coerceArray(value)
+	case "object":
+		value, err = s.coerceObject(value)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return value, s.check(value)
+}
+
+func (s *Schema) coerceArray(value interface{}) (interface{}, error) {
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected an array got %T", value)
+	}
+	if s.Items == nil {
+		return list, nil
+	}
+	result := make([]interface{}, len(list))
+	for i, item := range list {
+		v, err := s.Items.coerce(item)
+		if err != nil {
+			return nil, fmt.Errorf("item %d %v", i, err)
+		}
+		result[i] = v
+	}
+	return result, nil
+}
+
+func (s *Schema) coerceObject(value interface{}) (interface{}, error) {
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected an object got %T", value)
+	}
+	result := make(map[string]interface{}, len(object))
+	for k, v := range object {
+		result[k] = v
+	}
+	errs := s.Validate(result)
+	if len(errs) > 0 {
+		keys := make([]string, 0, len(errs))
+		for k := range errs {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		return nil, fmt.Errorf("property %s %s", keys[0], errs[keys[0]])
+	}
+	return result, nil
+}
+
+// check the constraints of the schema on a coerced value
+func (s *Schema) check(value interface{}) error {
+	if len(s.Enum) > 0 && !inEnum(s.Enum, value) {
+		return fmt.Errorf("%v is not one of the allowed values", value)
+	}
+	switch v := value.(type) {
+	case string:
+		if s.MinLength != nil && len(v) < *s.MinLength {
+			return fmt.Errorf("is shorter than %d characters", *s.MinLength)
+		}
+		if s.MaxLength != nil && len(v) > *s.MaxLength {
+			return fmt.Errorf("is longer than %d characters", *s.MaxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			return fmt.Errorf("%q does not match pattern %s", v, s.Pattern)
+		}
+		return checkFormat(s.Format, v)
+	case json.Number:
+		f, _ := v.Float64()
+		if s.Minimum != nil && f < *s.Minimum {
+			return fmt.Errorf("%v is less than the minimum %v", v, *s.Minimum)
+		}
+		if s.Maximum != nil && f > *s.Maximum {
+			return fmt.Errorf("%v is greater than the maximum %v", v, *s.Maximum)
+		}
+	}
+	return nil
+}
+
+func checkFormat(format string, v string) error {
+	switch format {
+	case "ip", "ipv4", "ipv6":
+		ip := net.ParseIP(v)
+		if ip == nil || (format == "ipv4" && ip.To4() == nil) || (format == "ipv6" && ip.To4() != nil) {
+			return fmt.Errorf("%q is not a valid %s address", v, format)
+		}
+	case "uri":
+		u, err := url.Parse(v)
+		if err != nil || u.Scheme == "" {
+			return fmt.Errorf("%q is not a valid uri", v)
+		}
+	case "date-time":
+		if _, err := time.Parse(time.RFC3339, v); err != nil {
+			return fmt.Errorf("%q is not a valid date-time", v)
+		}
+	}
+	return nil
+}
+
+func toString(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	}
+	return nil, fmt.Errorf("expected a string got %T", value)
+}
+
+func toNumber(value interface{}) (interface{}, error) {
+	var s string
+	switch v := value.(type) {
+	case json.Number:
+		s = v.String()
+	case string:
+		s = strings.TrimSpace(v)
+	case int:
+		s = strconv.Itoa(v)
+	case float64:
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return nil, fmt.Errorf("expected a number got %T", value)
+	}
+	if _, err := strconv.ParseFloat(s, 64); err != nil {
+		return nil, fmt.Errorf("%q is not a number", s)
+	}
+	return json.Number(s), nil
+}
+
+func toInteger(value interface{}) (interface{}, error) {
+	n, err := toNumber(value)
+	if err != nil {
+		return nil, errors.New(strings.Replace(err.Error(), "a number", "an integer", 1))
+	}
+	s := n.(json.Number).String()
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return json.Number(strconv.FormatInt(i, 10)), nil
+	}
+	// Parsed exactly so that large integers and forms like 4.0 or 1e3
+	// don't lose precision going through a float64
+	f, _, err := big.ParseFloat(s, 10, 512, big.ToNearestEven)
+	if err != nil || !f.IsInt() {
+		return nil, fmt.Errorf("%v is not an integer", n)
+	}
+	i, _ := f.Int(nil)
+	return json.Number(i.String()), nil
+}
+
+func toBoolean(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case bool:
+		return v, nil
+	case string:
+		b, err := strconv.ParseBool(strings.TrimSpace(v))
+		if err == nil {
+			return b, nil
+		}
+	}
+	return nil, fmt.Errorf("%v is not a boolean", value)
+}
+
+func inEnum(enum []interface{}, value interface{}) bool {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return false
+	}
+	for _, e := range enum {
+		eb, err := json.Marshal(e)
+		if err == nil && string(eb) == string(b) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func prefix(path string) string {
+	if path == "" {
+		return ""
+	}
+	return path + ": "
+}
+
+func join(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,146 @@
+package artifacts
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/mkanoor/catalog_worker/internal/jsontest"
+)
+
+func testSchema(t *testing.T) *Schema {
+	schema, err := ParseSchema(jsontest.Decode(t, `{"type": "object",
+		"required": ["expose_to_cloud_redhat_com_vm_ip"],
+		"properties": {
+			"expose_to_cloud_redhat_com_vm_ip": {"type": "string", "format": "ipv4"},
+			"expose_to_cloud_redhat_com_cpus": {"type": "integer", "minimum": 1, "maximum": 64},
+			"expose_to_cloud_redhat_com_ready": {"type": "boolean"},
+			"expose_to_cloud_redhat_com_size": {"type": "string", "enum": ["small", "large"]},
+			"expose_to_cloud_redhat_com_tags": {"type": "array", "items": {"type": "string", "pattern": "^[a-z]+$"}}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return schema
+}
+
+func TestSchemaCoerce(t *testing.T) {
+	data := jsontest.Decode(t, `{"expose_to_cloud_redhat_com_vm_ip": "10.1.1.1",
+		"expose_to_cloud_redhat_com_cpus": "4",
+		"expose_to_cloud_redhat_com_ready": "true",
+		"expose_to_cloud_redhat_com_tags": ["web", "db"],
+		"expose_to_cloud_redhat_com_other": 5}`)
+
+	errs := testSchema(t).Validate(data)
+	if len(errs) != 0 {
+		t.Fatalf("Unexpected errors %v", errs)
+	}
+	if data["expose_to_cloud_redhat_com_cpus"] != json.Number("4") {
+		t.Errorf("cpus was not coerced to a number %v", data["expose_to_cloud_redhat_com_cpus"])
+	}
+	if data["expose_to_cloud_redhat_com_ready"] != true {
+		t.Errorf("ready was not coerced to a boolean")
+	}
+	if data["expose_to_cloud_redhat_com_other"] != json.Number("5") {
+		t.Errorf("Undeclared keys should be left as is")
+	}
+}
+
+func TestSchemaLargeInteger(t *testing.T) {
+	schema, err := ParseSchema(jsontest.Decode(t, `{"type": "object", "properties": {"id": {"type": "integer"}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]json.Number{
+		`9007199254740993`:        "9007199254740993",
+		`"9223372036854775807"`:   "9223372036854775807",
+		`"123456789012345678901"`: "123456789012345678901",
+		`4.0`:                     "4",
+		`"1e3"`:                   "1000",
+	}
+	for value, expected := range tests {
+		data := jsontest.Decode(t, `{"id": `+value+`}`)
+		errs := schema.Validate(data)
+		if len(errs) != 0 || data["id"] != expected {
+			t.Errorf("%s should be %s got %v %v", value, expected, data["id"], errs)
+		}
+	}
+
+	data := jsontest.Decode(t, `{"id": "9007199254740993.5"}`)
+	if errs := schema.Validate(data); errs["id"] == "" {
+		t.Errorf("A fraction should not be an integer %v", data)
+	}
+}
+
+func TestSchemaErrors(t *testing.T) {
+	data := jsontest.Decode(t, `{"expose_to_cloud_redhat_com_cpus": 4.5,
+		"expose_to_cloud_redhat_com_ready": "maybe",
+		"expose_to_cloud_redhat_com_size": "medium",
+		"expose_to_cloud_redhat_com_tags": ["web", "DB"]}`)
+
+	errs := testSchema(t).Validate(data)
+	for _, k := range []string{"expose_to_cloud_redhat_com_vm_ip", "expose_to_cloud_redhat_com_cpus",
+		"expose_to_cloud_redhat_com_ready", "expose_to_cloud_redhat_com_size", "expose_to_cloud_redhat_com_tags"} {
+		if _, ok := errs[k]; !ok {
+			t.Errorf("Missing error for %s", k)
+		}
+		if _, ok := data[k]; ok {
+			t.Errorf("Invalid value for %s should be removed", k)
+		}
+	}
+	if errs["expose_to_cloud_redhat_com_vm_ip"] != "is required" {
+		t.Errorf("vm_ip error didn't match %s", errs["expose_to_cloud_redhat_com_vm_ip"])
+	}
+}
+
+func TestSchemaFormats(t *testing.T) {
+	tests := []struct {
+		format string
+		value  string
+		valid  bool
+	}{
+		{"ipv4", "10.0.0.1", true},
+		{"ipv4", "::1", false},
+		{"ipv6", "::1", true},
+		{"ip", "300.1.1.1", false},
+		{"uri", "https://example.com/a", true},
+		{"uri", "example", false},
+		{"date-time", "2020-10-19T10:00:00Z", true},
+		{"date-time", "yesterday", false},
+	}
+	for _, tc := range tests {
+		err := checkFormat(tc.format, tc.value)
+		if (err == nil) != tc.valid {
+			t.Errorf("Format %s value %s valid should be %v", tc.format, tc.value, tc.valid)
+		}
+	}
+}
+
+func TestParseSchemaInvalid(t *testing.T) {
+	tests := []string{
+		`{"type": "string"}`,
+		`{"properties": {"a": {"type": "date"}}}`,
+		`{"properties": {"a": {"type": "string", "format": "email"}}}`,
+		`{"properties": {"a": {"type": "string", "pattern": "(unclosed"}}}`,
+	}
+	for _, s := range tests {
+		if _, err := ParseSchema(jsontest.Decode(t, s)); err == nil {
+			t.Errorf("Schema %s did not fail", s)
+		}
+	}
+}
+
+func TestSanctifyAllSchemaErrors(t *testing.T) {
+	r := DefaultRules().WithSchema(testSchema(t))
+	body := map[string]interface{}{"artifacts": map[string]interface{}{"expose_to_cloud_redhat_com_vm_ip": "not an ip"}}
+	err := r.SanctifyAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs, ok := body["artifacts_errors"].(map[string]string)
+	if !ok || !strings.Contains(errs["expose_to_cloud_redhat_com_vm_ip"], "is not a valid ipv4 address") {
+		t.Errorf("Schema errors didn't match %v", body)
+	}
+	if DefaultRules().Schema != nil {
+		t.Error("WithSchema should not change the original rules")
+	}
+}
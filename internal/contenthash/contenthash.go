@@ -0,0 +1,59 @@
+package contenthash
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+)
+
+// Key is the attribute the hash of an object is stored in, it is left out
+// when the hash is computed
+const Key = "content_hash"
+
+// Prefix identifies the algorithm used for the hash
+const Prefix = "sha256:"
+
+// Sum returns the hash of the canonical JSON of an object. Keys are sorted,
+// HTML characters are not escaped and numbers are written in their
+// shortest form so the same object always has the same hash.
+func Sum(object map[string]interface{}) (string, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	err := encoder.Encode(canonical(object, true))
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(bytes.TrimRight(buf.Bytes(), "\n"))
+	return Prefix + hex.EncodeToString(sum[:]), nil
+}
+
+func canonical(value interface{}, top bool) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			if top && key == Key {
+				continue
+			}
+			result[key] = canonical(item, false)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = canonical(item, false)
+		}
+		return result
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return json.Number(strconv.FormatInt(n, 10))
+		}
+		if f, err := v.Float64(); err == nil {
+			return json.Number(strconv.FormatFloat(f, 'g', -1, 64))
+		}
+	}
+	return value
+}
@@ -0,0 +1,38 @@
+package contenthash
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mkanoor/catalog_worker/internal/jsontest"
+)
+
+func TestSumStable(t *testing.T) {
+	a, err := Sum(jsontest.Decode(t, `{"id": 1, "name": "<jt>", "extra": {"b": 1.50, "a": [1, 2]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Sum(jsontest.Decode(t, `{"extra": {"a": [1, 2], "b": 1.5}, "name": "<jt>", "id": 1, "content_hash": "sha256:old"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a != b {
+		t.Errorf("Hashes should match %s %s", a, b)
+	}
+	if !strings.HasPrefix(a, Prefix) {
+		t.Errorf("Hash is missing the prefix %s", a)
+	}
+}
+
+func TestSumChanged(t *testing.T) {
+	a, _ := Sum(jsontest.Decode(t, `{"id": 1, "name": "jt1"}`))
+	b, _ := Sum(jsontest.Decode(t, `{"id": 1, "name": "jt2"}`))
+	if a == b {
+		t.Errorf("Hashes should differ %s", a)
+	}
+	c, _ := Sum(jsontest.Decode(t, `{"id": 1, "name": "jt1", "extra": {"content_hash": "x"}}`))
+	d, _ := Sum(jsontest.Decode(t, `{"id": 1, "name": "jt1", "extra": {}}`))
+	if c == d {
+		t.Errorf("Only the top level content_hash should be ignored %s", c)
+	}
+}
@@ -0,0 +1,108 @@
+package credtypes
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Normalize converts an Ansible Tower credential type into a form schema.
+// Each entry of inputs.fields becomes a field with its type, whether it
+// is secret or required and the optional attributes used to render it.
+func Normalize(credentialType map[string]interface{}) (map[string]interface{}, error) {
+	inputs, ok := credentialType["inputs"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("Object is not a credential type, it does not contain inputs")
+	}
+
+	required := make(map[string]bool)
+	requiredList := []interface{}{}
+	if list, ok := inputs["required"].([]interface{}); ok {
+		for _, r := range list {
+			if id, ok := r.(string); ok {
+				required[id] = true
+				requiredList = append(requiredList, id)
+			}
+		}
+	}
+
+	fields := []interface{}{}
+	secretFields := []interface{}{}
+	if list, ok := inputs["fields"].([]interface{}); ok {
+		for i, f := range list {
+			input, ok := f.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("Credential type input %d is not an object", i+1)
+			}
+			field, err := normalizeField(input, required)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, field)
+			if field["secret"] == true {
+				secretFields = append(secretFields, field["id"])
+			}
+		}
+	}
+
+	result := map[string]interface{}{
+		"fields":        fields,
+		"required":      requiredList,
+		"secret_fields": secretFields,
+	}
+	for _, key := range []string{"id", "type", "url", "name", "description", "kind", "namespace", "managed_by_tower", "created", "modified"} {
+		if v, ok := credentialType[key]; ok {
+			result[key] = v
+		}
+	}
+	return result, nil
+}
+
+func normalizeField(input map[string]interface{}, required map[string]bool) (map[string]interface{}, error) {
+	id, _ := input["id"].(string)
+	if id == "" {
+		return nil, errors.New("Credential type input does not have an id")
+	}
+	fieldType, _ := input["type"].(string)
+	if fieldType == "" {
+		fieldType = "string"
+	}
+	if fieldType != "string" && fieldType != "boolean" {
+		return nil, fmt.Errorf("Credential type input %s has unknown type %q", id, fieldType)
+	}
+	label, _ := input["label"].(string)
+	if label == "" {
+		label = id
+	}
+	secret, _ := input["secret"].(bool)
+	format, _ := input["format"].(string)
+	if format == "ssh_private_key" {
+		secret = true
+	}
+
+	field := map[string]interface{}{
+		"id":       id,
+		"label":    label,
+		"type":     fieldType,
+		"secret":   secret,
+		"required": required[id],
+	}
+	if format != "" {
+		field["format"] = format
+	}
+	if multiline, _ := input["multiline"].(bool); multiline || format == "ssh_private_key" {
+		field["multiline"] = true
+	}
+	if askAtRuntime, _ := input["ask_at_runtime"].(bool); askAtRuntime {
+		field["ask_at_runtime"] = true
+	}
+	if choices, ok := input["choices"].([]interface{}); ok && len(choices) > 0 {
+		field["choices"] = choices
+	}
+	if help, _ := input["help_text"].(string); help != "" {
+		field["help_text"] = help
+	}
+	if def, ok := input["default"]; ok && def != nil {
+		field["default"] = def
+	}
+	return field, nil
+}
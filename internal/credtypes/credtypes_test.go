@@ -0,0 +1,82 @@
+package credtypes
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mkanoor/catalog_worker/internal/jsontest"
+)
+
+const machine = `{"id": 1, "type": "credential_type", "name": "Machine", "kind": "ssh", "namespace": "ssh", "managed_by_tower": true,
+	"injectors": {}, "related": {"credentials": "/api/v2/credential_types/1/credentials/"},
+	"inputs": {"fields": [
+		{"id": "username", "label": "Username", "type": "string"},
+		{"id": "password", "label": "Password", "type": "string", "secret": true, "ask_at_runtime": true},
+		{"id": "ssh_key_data", "label": "SSH Private Key", "type": "string", "format": "ssh_private_key", "secret": true, "multiline": true},
+		{"id": "become_method", "label": "Privilege Escalation Method", "type": "string", "choices": ["sudo", "su"], "help_text": "Specify a method"},
+		{"id": "verify", "label": "Verify", "type": "boolean", "default": true}],
+		"required": ["username"]}}`
+
+func TestNormalize(t *testing.T) {
+	result, err := Normalize(jsontest.Decode(t, machine))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result["name"] != "Machine" || result["kind"] != "ssh" || result["id"] != json.Number("1") {
+		t.Errorf("Credential type attributes didn't match %v", result)
+	}
+	if _, ok := result["injectors"]; ok {
+		t.Error("injectors should not be included")
+	}
+	if !reflect.DeepEqual(result["required"], []interface{}{"username"}) {
+		t.Errorf("Required didn't match %v", result["required"])
+	}
+	if !reflect.DeepEqual(result["secret_fields"], []interface{}{"password", "ssh_key_data"}) {
+		t.Errorf("Secret fields didn't match %v", result["secret_fields"])
+	}
+
+	fields := result["fields"].([]interface{})
+	if len(fields) != 5 {
+		t.Fatalf("Expected 5 fields got %d", len(fields))
+	}
+	expected := []map[string]interface{}{
+		{"id": "username", "label": "Username", "type": "string", "secret": false, "required": true},
+		{"id": "password", "label": "Password", "type": "string", "secret": true, "required": false, "ask_at_runtime": true},
+		{"id": "ssh_key_data", "label": "SSH Private Key", "type": "string", "secret": true, "required": false, "format": "ssh_private_key", "multiline": true},
+		{"id": "become_method", "label": "Privilege Escalation Method", "type": "string", "secret": false, "required": false,
+			"choices": []interface{}{"sudo", "su"}, "help_text": "Specify a method"},
+		{"id": "verify", "label": "Verify", "type": "boolean", "secret": false, "required": false, "default": true},
+	}
+	for i, e := range expected {
+		if !reflect.DeepEqual(fields[i], e) {
+			t.Errorf("Field %d didn't match %v", i, fields[i])
+		}
+	}
+}
+
+func TestNormalizeEmptyInputs(t *testing.T) {
+	result, err := Normalize(jsontest.Decode(t, `{"id": 2, "name": "Empty", "inputs": {}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result["fields"].([]interface{})) != 0 {
+		t.Errorf("Expected no fields %v", result)
+	}
+}
+
+func TestNormalizeInvalid(t *testing.T) {
+	tests := map[string]string{
+		`{"id": 2, "name": "Not a type"}`:                         "does not contain inputs",
+		`{"inputs": {"fields": ["username"]}}`:                    "is not an object",
+		`{"inputs": {"fields": [{"label": "User"}]}}`:             "does not have an id",
+		`{"inputs": {"fields": [{"id": "port", "type": "int"}]}}`: "unknown type",
+	}
+	for body, message := range tests {
+		_, err := Normalize(jsontest.Decode(t, body))
+		if err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("Body %s error %v should contain %s", body, err, message)
+		}
+	}
+}
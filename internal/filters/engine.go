@@ -0,0 +1,70 @@
+package filters
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// The filter languages supported in the filter_language of a job
+const (
+	JMESPath    = "jmespath"
+	JQ          = "jq"
+	JSONPointer = "json_pointer"
+)
+
+// Program is a compiled filter expression that can be run against
+// a decoded JSON body
+type Program interface {
+	Search(data interface{}) (interface{}, error)
+}
+
+// itemProgram is a Program that runs on each object in the results of a
+// list response when the filter replaces the results
+type itemProgram interface {
+	Program
+	perItem() bool
+}
+
+// streamProgram is a Program that emits a stream of values, when the
+// filter replaces the results the stream is the results list
+type streamProgram interface {
+	Program
+	stream(data interface{}) ([]interface{}, error)
+}
+
+// Engine compiles the expressions of a single filter language
+type Engine interface {
+	// Compile the expression into a Program
+	Compile(expression string) (Program, error)
+	// Build the expression for the object form of a filter
+	Build(element map[string]interface{}) (string, error)
+}
+
+var engines = map[string]Engine{
+	JMESPath:    jmespathEngine{},
+	JQ:          jqEngine{},
+	JSONPointer: pointerEngine{},
+}
+
+// Lookup the Engine for a filter language, an empty language
+// defaults to JMESPath
+func Lookup(language string) (Engine, error) {
+	if language == "" {
+		language = JMESPath
+	}
+	engine, ok := engines[strings.ToLower(language)]
+	if !ok {
+		return nil, fmt.Errorf("unknown filter language %s", language)
+	}
+	return engine, nil
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
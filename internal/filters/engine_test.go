@@ -0,0 +1,181 @@
+package filters
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/mkanoor/catalog_worker/internal/jsontest"
+)
+
+func TestUnknownLanguage(t *testing.T) {
+	f := Value{Language: "xpath"}
+	err := f.Parse("//id")
+	if err == nil {
+		t.Error("Parsing did not fail")
+	}
+}
+
+func TestJQStringFilter(t *testing.T) {
+	f := Value{Language: JQ}
+	err := f.Parse("[.results[] | {id, name}]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := `{"count": 2, "results":[{"id": 100, "name": "Fred", "age": 56}, {"id": 200, "name": "Barney", "state": "NY"}]}`
+	result, err := f.Apply(jsontest.Decode(t, body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := result["results"].([]interface{})
+	item := x[1].(map[string]interface{})
+	if item["name"].(string) != "Barney" {
+		t.Error("Name didn't match Barney")
+	}
+	if item["id"].(int) != 200 {
+		t.Error("id didn't match 200")
+	}
+	if _, found := item["state"]; found {
+		t.Error("state should be missing")
+	}
+}
+
+func TestJQStringFilterPageSizes(t *testing.T) {
+	tests := map[string]int{
+		`{"count": 0, "results": []}`:                                       0,
+		`{"count": 1, "results": [{"id": 100, "name": "Fred", "age": 56}]}`: 1,
+	}
+	for body, count := range tests {
+		f := Value{Language: JQ}
+		err := f.Parse(".results[] | {id, name}")
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := f.Apply(jsontest.Decode(t, body))
+		if err != nil {
+			t.Fatalf("%s failed %v", body, err)
+		}
+		results, ok := result["results"].([]interface{})
+		if !ok || len(results) != count {
+			t.Errorf("results didn't match %d items %v", count, result["results"])
+		}
+		if count == 1 && !reflect.DeepEqual(results[0], map[string]interface{}{"id": 100, "name": "Fred"}) {
+			t.Errorf("result didn't match %v", results[0])
+		}
+	}
+}
+
+func TestJQMapFilter(t *testing.T) {
+	f := Value{Language: JQ}
+	v := map[string]interface{}{"id": ".id", "upper": ".name | ascii_upcase", "related": map[string]interface{}{"inventory": ".related.inventory"}}
+	err := f.Parse(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := `{"id": 100, "name": "Fred", "related": {"inventory": "/api/v2/inventories/1/"}}`
+	result, err := f.Apply(jsontest.Decode(t, body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result["upper"].(string) != "FRED" {
+		t.Error("upper didn't match FRED")
+	}
+	related := result["related"].(map[string]interface{})
+	if related["inventory"].(string) != "/api/v2/inventories/1/" {
+		t.Error("Nested inventory didn't match")
+	}
+}
+
+func TestJQBadExpression(t *testing.T) {
+	f := Value{Language: JQ}
+	err := f.Parse(".results[] |")
+	if err == nil {
+		t.Error("Parsing did not fail")
+	}
+}
+
+func TestPointerMapFilter(t *testing.T) {
+	f := Value{Language: JSONPointer}
+	v := map[string]interface{}{"id": "/id", "inventory": "/related/inventory", "first_tag": "/tags/0", "missing": "/nope"}
+	err := f.Parse(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := `{"id": 100, "name": "Fred", "tags": ["a", "b"], "related": {"inventory": "/api/v2/inventories/1/"}}`
+	result, err := f.Apply(jsontest.Decode(t, body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result["inventory"].(string) != "/api/v2/inventories/1/" {
+		t.Error("inventory didn't match")
+	}
+	if result["first_tag"].(string) != "a" {
+		t.Error("first_tag didn't match a")
+	}
+	if result["missing"] != nil {
+		t.Error("missing should be nil")
+	}
+	if _, found := result["name"]; found {
+		t.Error("name should be missing")
+	}
+}
+
+func TestPointerStringFilter(t *testing.T) {
+	f := Value{Language: JSONPointer}
+	err := f.Parse("/results")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := `{"count": 1, "results":[{"id": 100, "name": "Fred"}]}`
+	result, err := f.Apply(jsontest.Decode(t, body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result["results"].([]interface{})) != 1 {
+		t.Error("results length didn't match 1")
+	}
+}
+
+func TestPointerStringFilterEachResult(t *testing.T) {
+	f := Value{Language: JSONPointer}
+	err := f.Parse(`{"id": "/id", "inventory": "/related/inventory"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := `{"count": 2, "results":[{"id": 100, "name": "Fred", "related": {"inventory": "/api/v2/inventories/1/"}}, {"id": 101, "name": "Barney"}]}`
+	result, err := f.Apply(jsontest.Decode(t, body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []interface{}{
+		map[string]interface{}{"id": json.Number("100"), "inventory": "/api/v2/inventories/1/"},
+		map[string]interface{}{"id": json.Number("101"), "inventory": nil},
+	}
+	if !reflect.DeepEqual(result["results"], expected) {
+		t.Errorf("results didn't match %v", result["results"])
+	}
+	if result["count"] != json.Number("2") {
+		t.Errorf("count should be kept %v", result["count"])
+	}
+
+	f = Value{Language: JSONPointer}
+	err = f.Parse("/name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err = f.Apply(jsontest.Decode(t, body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result["results"], []interface{}{"Fred", "Barney"}) {
+		t.Errorf("results didn't match %v", result["results"])
+	}
+}
+
+func TestPointerBadExpression(t *testing.T) {
+	f := Value{Language: JSONPointer}
+	err := f.Parse(map[string]interface{}{"id": "id"})
+	if err == nil {
+		t.Error("Parsing did not fail")
+	}
+}
@@ -1,63 +1,230 @@
 package filters
 
 import (
-	"strings"
+	"fmt"
+	"sync"
 
-	"github.com/jmespath/go-jmespath"
 	log "github.com/sirupsen/logrus"
 )
 
-// Value stores the parsed data of the JMESPath expression
+// DefaultWrapKey is the key used to wrap a filter result that is not an object
+const DefaultWrapKey = "result"
+
+// Value stores the parsed data of the filter expression
 type Value struct {
 	Data           string
 	ReplaceResults bool
+	WrapKey        string
+	Language       string
+	compiled       Program
+	steps          []step
+}
+
+// ParseError is returned when a filter expression cannot be compiled
+type ParseError struct {
+	Expression string
+	Err        error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("Invalid filter expression %q: %v", e.Expression, e.Err)
+}
+
+// TypeError is returned when the result of a filter does not have the
+// shape needed to build the response
+type TypeError struct {
+	Expression string
+	Expected   string
+	Actual     interface{}
 }
 
-// Apply the JMESPath filter to the JSON body recieved from
-// Ansible Tower
+func (e *TypeError) Error() string {
+	return fmt.Sprintf("Filter %q returned %s, expected %s", e.Expression, typeName(e.Actual), e.Expected)
+}
+
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case map[string]interface{}:
+		return "an object"
+	case []interface{}:
+		return "a list"
+	case string:
+		return "a string"
+	case bool:
+		return "a boolean"
+	default:
+		return "a number"
+	}
+}
+
+// The worker handles a single request per process so the compiled
+// expressions are cached for all the jobs in that request.
+var cache = struct {
+	sync.Mutex
+	programs map[string]Program
+}{programs: make(map[string]Program)}
+
+func compile(language string, expression string) (Program, error) {
+	engine, err := Lookup(language)
+	if err != nil {
+		return nil, &ParseError{Expression: expression, Err: err}
+	}
+	key := language + "\x00" + expression
+	cache.Lock()
+	defer cache.Unlock()
+	if precompiled, ok := cache.programs[key]; ok {
+		return precompiled, nil
+	}
+	precompiled, err := engine.Compile(expression)
+	if err != nil {
+		return nil, &ParseError{Expression: expression, Err: err}
+	}
+	cache.programs[key] = precompiled
+	return precompiled, nil
+}
+
+// Apply the filter to the JSON body recieved from
+// Ansible Tower. When the results collection is being replaced the filter
+// has to return a list. Otherwise an object result becomes the new body and
+// any other result (list, scalar or null) is wrapped under the WrapKey.
 func (f *Value) Apply(jsonBody map[string]interface{}) (map[string]interface{}, error) {
-	precompiled, err := jmespath.Compile(f.Data)
+	if len(f.steps) > 0 {
+		return f.applySteps(jsonBody)
+	}
+	if f.compiled == nil {
+		precompiled, err := compile(f.Language, f.Data)
+		if err != nil {
+			log.Error(err)
+			return nil, err
+		}
+		f.compiled = precompiled
+	}
+	if p, ok := f.compiled.(streamProgram); ok && f.ReplaceResults {
+		return f.replaceWithStream(p, jsonBody)
+	}
+	if p, ok := f.compiled.(itemProgram); ok && f.ReplaceResults && p.perItem() {
+		if results, ok := jsonBody["results"].([]interface{}); ok {
+			return f.applyToResults(p, jsonBody, results)
+		}
+	}
+	result, err := f.compiled.Search(jsonBody)
 	if err != nil {
 		log.Error(err)
 		return nil, err
 	}
-	result, err := precompiled.Search(jsonBody)
+	if f.ReplaceResults {
+		if _, ok := result.([]interface{}); !ok {
+			err = &TypeError{Expression: f.Data, Expected: "a list", Actual: result}
+			log.Error(err)
+			return nil, err
+		}
+		jsonBody["results"] = result
+		return jsonBody, nil
+	}
+
+	if object, ok := result.(map[string]interface{}); ok {
+		return object, nil
+	}
+	key := f.WrapKey
+	if key == "" {
+		key = DefaultWrapKey
+	}
+	return map[string]interface{}{key: result}, nil
+}
+
+// replaceWithStream replaces the results with the values emitted by the
+// program, a program that emits a single list replaces them with the list
+func (f *Value) replaceWithStream(p streamProgram, jsonBody map[string]interface{}) (map[string]interface{}, error) {
+	results, err := p.stream(jsonBody)
 	if err != nil {
 		log.Error(err)
 		return nil, err
 	}
-	if f.ReplaceResults {
-		jsonBody["results"] = result
-	} else {
-		jsonBody = result.(map[string]interface{})
+	if len(results) == 1 {
+		if list, ok := results[0].([]interface{}); ok {
+			results = list
+		}
+	}
+	jsonBody["results"] = results
+	return jsonBody, nil
+}
+
+// applyToResults replaces each object in the results with the result of
+// the program run on it
+func (f *Value) applyToResults(p Program, jsonBody map[string]interface{}, results []interface{}) (map[string]interface{}, error) {
+	replaced := make([]interface{}, len(results))
+	for i, item := range results {
+		result, err := p.Search(item)
+		if err != nil {
+			log.Error(err)
+			return nil, err
+		}
+		replaced[i] = result
+	}
+	jsonBody["results"] = replaced
+	return jsonBody, nil
+}
+
+func (f *Value) applySteps(jsonBody map[string]interface{}) (map[string]interface{}, error) {
+	var err error
+	for _, s := range f.steps {
+		jsonBody, err = s.apply(jsonBody)
+		if err != nil {
+			log.Error(err)
+			return nil, err
+		}
 	}
 	return jsonBody, nil
 }
 
-// Parse the filter value which can be a string or map.
+// Parse the filter value which can be a string, map or list.
+// The map values can themselves be maps or lists for nested objects,
+// the filter Language decides how the map is turned into an expression.
 // The map is typically used when working with a single object response
 // The string filter value is used when working with a list response which
 // can contain multiple objects and the filter needs to be applied to each
 // object and the results collection be updated.
-func (f *Value) Parse(element interface{}) {
+// The list is a pipeline of steps (filter, sort_by, limit, dedupe, rename
+// and drop_nulls) which are applied in order.
+// The expression is compiled here so that a bad filter is reported before
+// any calls are made to Ansible Tower.
+func (f *Value) Parse(element interface{}) error {
 	switch element.(type) {
 	case string:
 		f.Data = element.(string)
 		f.ReplaceResults = true
 	case map[string]interface{}:
-		var sb strings.Builder
-		for key, value := range element.(map[string]interface{}) {
-			switch value.(type) {
-			case string:
-				if sb.Len() == 0 {
-					sb.WriteString("{")
-				} else {
-					sb.WriteString(",")
-				}
-				sb.WriteString(key + ":" + value.(string))
-			}
-		}
-		sb.WriteString("}")
-		f.Data = sb.String()
+		engine, err := Lookup(f.Language)
+		if err != nil {
+			err = &ParseError{Expression: fmt.Sprintf("%v", element), Err: err}
+			log.Error(err)
+			return err
+		}
+		data, err := engine.Build(element.(map[string]interface{}))
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+		f.Data = data
+	case []interface{}:
+		err := f.parseSteps(element.([]interface{}))
+		if err != nil {
+			err = &ParseError{Expression: fmt.Sprintf("%v", element), Err: err}
+			log.Error(err)
+			return err
+		}
+		return nil
+	default:
+		return &ParseError{Expression: fmt.Sprintf("%v", element), Err: fmt.Errorf("unsupported filter type %T", element)}
+	}
+
+	precompiled, err := compile(f.Language, f.Data)
+	if err != nil {
+		log.Error(err)
+		return err
 	}
+	f.compiled = precompiled
+	return nil
 }
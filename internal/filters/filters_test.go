@@ -3,7 +3,10 @@ package filters
 import (
 	"bytes"
 	"encoding/json"
+	"strings"
 	"testing"
+
+	"github.com/mkanoor/catalog_worker/internal/jsontest"
 )
 
 func TestMapFilterBadValue(t *testing.T) {
@@ -93,3 +96,156 @@ func TestFilterMapStringValue(t *testing.T) {
 		t.Error("Results should not be replaced")
 	}
 }
+
+func TestParseBadExpression(t *testing.T) {
+	f := Value{}
+	err := f.Parse("results[].{id:id")
+	if err == nil {
+		t.Fatal("Parsing did not fail")
+	}
+	if _, ok := err.(*ParseError); !ok {
+		t.Errorf("Expected a ParseError got %T", err)
+	}
+}
+
+func TestParseUnsupportedType(t *testing.T) {
+	f := Value{}
+	err := f.Parse(42)
+	if err == nil {
+		t.Error("Parsing did not fail")
+	}
+}
+
+func TestParseCachesExpression(t *testing.T) {
+	f1 := Value{}
+	f2 := Value{}
+	if err := f1.Parse("results[].{id:id, name:name}"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f2.Parse("results[].{id:id, name:name}"); err != nil {
+		t.Fatal(err)
+	}
+	if f1.compiled != f2.compiled {
+		t.Error("Compiled expression was not reused")
+	}
+}
+
+func TestFilterNestedMap(t *testing.T) {
+	f := Value{}
+	v := map[string]interface{}{
+		"name":    "name",
+		"id":      "id",
+		"related": map[string]interface{}{"inventory": "related.inventory"},
+		"ids":     []interface{}{"id", "name"},
+	}
+	err := f.Parse(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{id:id,ids:[id,name],name:name,related:{inventory:related.inventory}}`
+	if f.Data != expected {
+		t.Errorf("Expression %s didn't match %s", f.Data, expected)
+	}
+
+	body := `{"id": 100, "name": "Fred", "age": 56, "related": {"inventory": "/api/v2/inventories/1/", "project": "/api/v2/projects/1/"}}`
+	var jsonBody map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader([]byte(body)))
+	decoder.UseNumber()
+	err = decoder.Decode(&jsonBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := f.Apply(jsonBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	related := result["related"].(map[string]interface{})
+	if related["inventory"].(string) != "/api/v2/inventories/1/" {
+		t.Error("Nested inventory didn't match")
+	}
+	if _, found := related["project"]; found {
+		t.Error("project should be missing")
+	}
+	if len(result["ids"].([]interface{})) != 2 {
+		t.Error("ids list length didn't match 2")
+	}
+}
+
+func TestFilterQuotedKey(t *testing.T) {
+	f := Value{}
+	err := f.Parse(map[string]interface{}{"catalog-id": "id"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Data != `{"catalog-id":id}` {
+		t.Errorf("Expression %s was not quoted", f.Data)
+	}
+}
+
+func TestFilterUnsupportedMapValue(t *testing.T) {
+	f := Value{}
+	err := f.Parse(map[string]interface{}{"id": 5})
+	if err == nil {
+		t.Error("Parsing did not fail")
+	}
+}
+
+func TestObjectResult(t *testing.T) {
+	f := Value{Data: "related"}
+	result, err := f.Apply(jsontest.Decode(t, `{"id": 1, "related": {"inventory": "/api/v2/inventories/1/"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := result["inventory"]; !ok {
+		t.Error("Object result should replace the body")
+	}
+}
+
+func TestListResult(t *testing.T) {
+	f := Value{Data: "[id, name]"}
+	result, err := f.Apply(jsontest.Decode(t, `{"id": 1, "name": "Fred"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, ok := result[DefaultWrapKey].([]interface{})
+	if !ok || len(list) != 2 {
+		t.Errorf("List result was not wrapped %v", result)
+	}
+}
+
+func TestScalarResult(t *testing.T) {
+	f := Value{Data: "name", WrapKey: "value"}
+	result, err := f.Apply(jsontest.Decode(t, `{"id": 1, "name": "Fred"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result["value"].(string) != "Fred" {
+		t.Errorf("Scalar result was not wrapped under value %v", result)
+	}
+}
+
+func TestNullResult(t *testing.T) {
+	f := Value{Data: "missing"}
+	result, err := f.Apply(jsontest.Decode(t, `{"id": 1, "name": "Fred"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, ok := result[DefaultWrapKey]
+	if !ok || value != nil {
+		t.Errorf("Null result was not wrapped %v", result)
+	}
+}
+
+func TestReplaceResultsTypeMismatch(t *testing.T) {
+	f := Value{Data: "{id:id}", ReplaceResults: true}
+	_, err := f.Apply(jsontest.Decode(t, `{"id": 1, "name": "Fred"}`))
+	if err == nil {
+		t.Fatal("Apply did not fail")
+	}
+	if _, ok := err.(*TypeError); !ok {
+		t.Errorf("Expected a TypeError got %T", err)
+	}
+	if !strings.Contains(err.Error(), "returned an object, expected a list") {
+		t.Errorf("Error message didn't match %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+package filters
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/jmespath/go-jmespath"
+)
+
+type jmespathEngine struct{}
+
+func (jmespathEngine) Compile(expression string) (Program, error) {
+	return jmespath.Compile(expression)
+}
+
+func (jmespathEngine) Build(element map[string]interface{}) (string, error) {
+	return buildExpression(element)
+}
+
+// buildExpression converts the object form of a filter into a JMESPath
+// expression. Strings are used as is, maps become a multiselect hash and
+// lists a multiselect list, nested to any depth. Map keys are sorted so the
+// same filter always generates the same expression.
+func buildExpression(element interface{}) (string, error) {
+	switch v := element.(type) {
+	case string:
+		return v, nil
+	case map[string]interface{}:
+		if len(v) == 0 {
+			return "", &ParseError{Expression: "{}", Err: errors.New("empty object in filter")}
+		}
+		keys := sortedKeys(v)
+		parts := make([]string, len(keys))
+		for i, key := range keys {
+			expr, err := buildExpression(v[key])
+			if err != nil {
+				return "", err
+			}
+			parts[i] = quoteIdentifier(key) + ":" + expr
+		}
+		return "{" + strings.Join(parts, ",") + "}", nil
+	case []interface{}:
+		if len(v) == 0 {
+			return "", &ParseError{Expression: "[]", Err: errors.New("empty list in filter")}
+		}
+		parts := make([]string, len(v))
+		for i, item := range v {
+			expr, err := buildExpression(item)
+			if err != nil {
+				return "", err
+			}
+			parts[i] = expr
+		}
+		return "[" + strings.Join(parts, ",") + "]", nil
+	default:
+		return "", &ParseError{Expression: fmt.Sprintf("%v", element), Err: fmt.Errorf("unsupported filter value type %T", element)}
+	}
+}
+
+var identifierRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// quoteIdentifier returns the key as a JMESPath quoted identifier when it
+// contains characters that are not allowed in an unquoted identifier
+func quoteIdentifier(key string) string {
+	if identifierRegexp.MatchString(key) {
+		return key
+	}
+	b, _ := json.Marshal(key)
+	return string(b)
+}
@@ -0,0 +1,129 @@
+package filters
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/itchyny/gojq"
+)
+
+type jqEngine struct{}
+
+type jqProgram struct {
+	code *gojq.Code
+}
+
+func (jqEngine) Compile(expression string) (Program, error) {
+	query, err := gojq.Parse(expression)
+	if err != nil {
+		return nil, err
+	}
+	code, err := gojq.Compile(query)
+	if err != nil {
+		return nil, err
+	}
+	return &jqProgram{code: code}, nil
+}
+
+// Build a jq object construction from the object form, the values
+// are jq filters relative to the current object
+func (jqEngine) Build(element map[string]interface{}) (string, error) {
+	return buildJQ(element)
+}
+
+func buildJQ(element interface{}) (string, error) {
+	switch v := element.(type) {
+	case string:
+		return "(" + v + ")", nil
+	case map[string]interface{}:
+		if len(v) == 0 {
+			return "", errors.New("empty object in filter")
+		}
+		keys := sortedKeys(v)
+		parts := make([]string, len(keys))
+		for i, key := range keys {
+			expr, err := buildJQ(v[key])
+			if err != nil {
+				return "", err
+			}
+			quoted, _ := json.Marshal(key)
+			parts[i] = string(quoted) + ": " + expr
+		}
+		return "{" + strings.Join(parts, ", ") + "}", nil
+	case []interface{}:
+		if len(v) == 0 {
+			return "", errors.New("empty list in filter")
+		}
+		parts := make([]string, len(v))
+		for i, item := range v {
+			expr, err := buildJQ(item)
+			if err != nil {
+				return "", err
+			}
+			parts[i] = expr
+		}
+		return "[" + strings.Join(parts, ", ") + "]", nil
+	default:
+		return "", fmt.Errorf("unsupported filter value type %T", element)
+	}
+}
+
+// Search runs the jq program, a program that emits more than
+// one value has its values collected into a list
+func (p *jqProgram) Search(data interface{}) (interface{}, error) {
+	results, err := p.stream(data)
+	if err != nil {
+		return nil, err
+	}
+	switch len(results) {
+	case 0:
+		return nil, nil
+	case 1:
+		return results[0], nil
+	}
+	return results, nil
+}
+
+// stream runs the jq program and returns all the values it emits
+func (p *jqProgram) stream(data interface{}) ([]interface{}, error) {
+	iter := p.code.Run(normalizeNumbers(data))
+	results := []interface{}{}
+	for {
+		v, ok := iter.Next()
+		if !ok {
+			return results, nil
+		}
+		if err, ok := v.(error); ok {
+			return nil, err
+		}
+		results = append(results, v)
+	}
+}
+
+// normalizeNumbers converts json.Number values, which gojq does not
+// accept, into ints or floats
+func normalizeNumbers(data interface{}) interface{} {
+	switch v := data.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return int(i)
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			result[key] = normalizeNumbers(value)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, value := range v {
+			result[i] = normalizeNumbers(value)
+		}
+		return result
+	}
+	return data
+}
@@ -0,0 +1,323 @@
+package filters
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// step is a single stage of a filter pipeline. The list steps (sort_by,
+// limit and dedupe) work on the results collection of a list response,
+// the object steps (rename and drop_nulls) work on each object in the
+// results or on the body of a single object response.
+type step interface {
+	apply(jsonBody map[string]interface{}) (map[string]interface{}, error)
+}
+
+// parseSteps parses a pipeline, each element is an object with a single
+// key naming the step e.g. [{"filter": "..."}, {"sort_by": "-name"}, {"limit": 10}]
+func (f *Value) parseSteps(elements []interface{}) error {
+	if len(elements) == 0 {
+		return errors.New("empty filter pipeline")
+	}
+	for i, element := range elements {
+		m, ok := element.(map[string]interface{})
+		if !ok || len(m) != 1 {
+			return fmt.Errorf("pipeline step %d must be an object with a single key", i+1)
+		}
+		for name, arg := range m {
+			s, err := f.parseStep(name, arg)
+			if err != nil {
+				return fmt.Errorf("pipeline step %d %s: %v", i+1, name, err)
+			}
+			f.steps = append(f.steps, s)
+		}
+	}
+	return nil
+}
+
+func (f *Value) parseStep(name string, arg interface{}) (step, error) {
+	switch name {
+	case "filter":
+		v := &Value{Language: f.Language, WrapKey: f.WrapKey}
+		if _, ok := arg.([]interface{}); ok {
+			return nil, errors.New("pipelines cannot be nested")
+		}
+		err := v.Parse(arg)
+		if err != nil {
+			return nil, err
+		}
+		return v, nil
+	case "sort_by":
+		key, ok := arg.(string)
+		if !ok || key == "" || key == "-" {
+			return nil, errors.New("expects a key name, prefix with - to sort descending")
+		}
+		if strings.HasPrefix(key, "-") {
+			return &sortStep{key: key[1:], descending: true}, nil
+		}
+		return &sortStep{key: key}, nil
+	case "limit":
+		n, err := toInt(arg)
+		if err != nil || n < 0 {
+			return nil, errors.New("expects a non negative number")
+		}
+		return &limitStep{count: n}, nil
+	case "dedupe":
+		switch v := arg.(type) {
+		case string:
+			return &dedupeStep{key: v}, nil
+		case bool:
+			if v {
+				return &dedupeStep{}, nil
+			}
+		}
+		return nil, errors.New("expects a key name or true")
+	case "rename":
+		m, ok := arg.(map[string]interface{})
+		if !ok || len(m) == 0 {
+			return nil, errors.New("expects an object of old to new key names")
+		}
+		names := make(map[string]string, len(m))
+		for from, to := range m {
+			s, ok := to.(string)
+			if !ok {
+				return nil, fmt.Errorf("new name for %s must be a string", from)
+			}
+			names[from] = s
+		}
+		return &renameStep{names: names}, nil
+	case "drop_nulls":
+		if v, ok := arg.(bool); !ok || !v {
+			return nil, errors.New("expects true")
+		}
+		return &dropNullsStep{}, nil
+	}
+	return nil, errors.New("unknown pipeline step")
+}
+
+// isListStep checks if a step needs all the results of a list response,
+// run on each page it only sorts, limits or dedupes that page
+func isListStep(s step) bool {
+	switch s.(type) {
+	case *sortStep, *limitStep, *dedupeStep:
+		return true
+	}
+	return false
+}
+
+// HasListSteps checks if the pipeline has a sort_by, limit or dedupe step
+func (f *Value) HasListSteps() bool {
+	for _, s := range f.steps {
+		if isListStep(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// SplitAtListSteps splits the pipeline at its first list step. The steps
+// before it can run on each page, the rest run once on the merged results
+// of all the pages. merged is nil when there are no list steps and page
+// is nil when the pipeline starts with one.
+func (f *Value) SplitAtListSteps() (page *Value, merged *Value) {
+	for i, s := range f.steps {
+		if !isListStep(s) {
+			continue
+		}
+		merged = &Value{Language: f.Language, WrapKey: f.WrapKey, steps: f.steps[i:]}
+		if i > 0 {
+			page = &Value{Language: f.Language, WrapKey: f.WrapKey, steps: f.steps[:i]}
+		}
+		return page, merged
+	}
+	return f, nil
+}
+
+// apply lets a nested filter Value be used as a pipeline step
+func (f *Value) apply(jsonBody map[string]interface{}) (map[string]interface{}, error) {
+	return f.Apply(jsonBody)
+}
+
+func toInt(v interface{}) (int, error) {
+	switch n := v.(type) {
+	case json.Number:
+		i, err := n.Int64()
+		return int(i), err
+	case float64:
+		return int(n), nil
+	case int:
+		return n, nil
+	}
+	return 0, fmt.Errorf("%v is not a number", v)
+}
+
+func resultsList(jsonBody map[string]interface{}, name string) ([]interface{}, error) {
+	results, ok := jsonBody["results"].([]interface{})
+	if !ok {
+		return nil, &TypeError{Expression: name, Expected: "a list response", Actual: jsonBody["results"]}
+	}
+	return results, nil
+}
+
+// EachObject calls fn on every object in the results of a list response
+// or on the body itself for a single object response
+func EachObject(jsonBody map[string]interface{}, fn func(map[string]interface{})) {
+	results, ok := jsonBody["results"].([]interface{})
+	if !ok {
+		fn(jsonBody)
+		return
+	}
+	for _, item := range results {
+		if object, ok := item.(map[string]interface{}); ok {
+			fn(object)
+		}
+	}
+}
+
+type sortStep struct {
+	key        string
+	descending bool
+}
+
+func (s *sortStep) apply(jsonBody map[string]interface{}) (map[string]interface{}, error) {
+	results, err := resultsList(jsonBody, "sort_by")
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := fieldOf(results[i], s.key), fieldOf(results[j], s.key)
+		if s.descending {
+			return less(b, a)
+		}
+		return less(a, b)
+	})
+	return jsonBody, nil
+}
+
+func fieldOf(item interface{}, key string) interface{} {
+	if object, ok := item.(map[string]interface{}); ok {
+		return object[key]
+	}
+	return nil
+}
+
+// less orders nulls first, then numbers, then strings
+func less(a, b interface{}) bool {
+	rank := func(v interface{}) int {
+		switch v.(type) {
+		case nil:
+			return 0
+		case json.Number, float64, int:
+			return 1
+		case string:
+			return 2
+		}
+		return 3
+	}
+	if rank(a) != rank(b) {
+		return rank(a) < rank(b)
+	}
+	switch x := a.(type) {
+	case string:
+		return x < b.(string)
+	case json.Number, float64, int:
+		return toFloat(a) < toFloat(b)
+	}
+	return false
+}
+
+func toFloat(v interface{}) float64 {
+	switch n := v.(type) {
+	case json.Number:
+		f, _ := n.Float64()
+		return f
+	case float64:
+		return n
+	case int:
+		return float64(n)
+	}
+	return 0
+}
+
+type limitStep struct {
+	count int
+}
+
+func (s *limitStep) apply(jsonBody map[string]interface{}) (map[string]interface{}, error) {
+	results, err := resultsList(jsonBody, "limit")
+	if err != nil {
+		return nil, err
+	}
+	if len(results) > s.count {
+		jsonBody["results"] = results[:s.count]
+	}
+	return jsonBody, nil
+}
+
+// dedupeStep keeps the first object for each value of key, or for
+// each distinct object when no key is given
+type dedupeStep struct {
+	key string
+}
+
+func (s *dedupeStep) apply(jsonBody map[string]interface{}) (map[string]interface{}, error) {
+	results, err := resultsList(jsonBody, "dedupe")
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	unique := make([]interface{}, 0, len(results))
+	for _, item := range results {
+		value := item
+		if s.key != "" {
+			value = fieldOf(item, s.key)
+		}
+		b, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		if seen[string(b)] {
+			continue
+		}
+		seen[string(b)] = true
+		unique = append(unique, item)
+	}
+	jsonBody["results"] = unique
+	return jsonBody, nil
+}
+
+type renameStep struct {
+	names map[string]string
+}
+
+func (s *renameStep) apply(jsonBody map[string]interface{}) (map[string]interface{}, error) {
+	EachObject(jsonBody, func(object map[string]interface{}) {
+		renamed := make(map[string]interface{})
+		for from, to := range s.names {
+			if value, ok := object[from]; ok {
+				renamed[to] = value
+				delete(object, from)
+			}
+		}
+		for key, value := range renamed {
+			object[key] = value
+		}
+	})
+	return jsonBody, nil
+}
+
+type dropNullsStep struct{}
+
+func (s *dropNullsStep) apply(jsonBody map[string]interface{}) (map[string]interface{}, error) {
+	EachObject(jsonBody, func(object map[string]interface{}) {
+		for key, value := range object {
+			if value == nil {
+				delete(object, key)
+			}
+		}
+	})
+	return jsonBody, nil
+}
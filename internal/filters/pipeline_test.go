@@ -0,0 +1,149 @@
+package filters
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/mkanoor/catalog_worker/internal/jsontest"
+)
+
+func TestPipeline(t *testing.T) {
+	f := Value{}
+	steps := jsontest.Decode(t, `{"steps": [
+		{"filter": "results[].{id:id, name:name, description:description}"},
+		{"dedupe": "id"},
+		{"sort_by": "-name"},
+		{"limit": 2},
+		{"rename": {"id": "catalog_id"}},
+		{"drop_nulls": true}]}`)["steps"]
+	err := f.Parse(steps)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := `{"count": 4, "results":[
+		{"id": 1, "name": "Barney", "description": null},
+		{"id": 2, "name": "Wilma", "description": "wife"},
+		{"id": 1, "name": "Barney", "description": null},
+		{"id": 3, "name": "Fred", "description": null}]}`
+	result, err := f.Apply(jsontest.Decode(t, body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	x := result["results"].([]interface{})
+	if len(x) != 2 {
+		t.Fatalf("results length %d didn't match 2", len(x))
+	}
+	first := x[0].(map[string]interface{})
+	if first["name"].(string) != "Wilma" {
+		t.Error("Name didn't match Wilma")
+	}
+	second := x[1].(map[string]interface{})
+	if second["name"].(string) != "Fred" {
+		t.Error("Name didn't match Fred")
+	}
+	if _, found := second["description"]; found {
+		t.Error("null description should be dropped")
+	}
+	if _, found := second["id"]; found {
+		t.Error("id should be renamed")
+	}
+	if _, found := second["catalog_id"]; !found {
+		t.Error("catalog_id should be present")
+	}
+}
+
+func TestPipelineSplitAtListSteps(t *testing.T) {
+	f := Value{}
+	err := f.Parse([]interface{}{
+		map[string]interface{}{"filter": "results[].{id:id, name:name}"},
+		map[string]interface{}{"sort_by": "-id"},
+		map[string]interface{}{"limit": 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.HasListSteps() {
+		t.Fatal("Pipeline should have list steps")
+	}
+	page, merged := f.SplitAtListSteps()
+	if len(page.steps) != 1 || len(merged.steps) != 2 {
+		t.Fatalf("Split didn't match %v %v", page.steps, merged.steps)
+	}
+
+	var results []interface{}
+	for _, body := range []string{`{"results": [{"id": 1, "kind": "a"}, {"id": 2}]}`, `{"results": [{"id": 3}, {"id": 4}]}`} {
+		result, err := page.Apply(jsontest.Decode(t, body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, result["results"].([]interface{})...)
+	}
+	result, err := merged.Apply(map[string]interface{}{"results": results})
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := result["results"].([]interface{})
+	if len(x) != 1 || x[0].(map[string]interface{})["id"] != json.Number("4") {
+		t.Errorf("Merged results didn't match %v", x)
+	}
+
+	f = Value{}
+	err = f.Parse([]interface{}{map[string]interface{}{"rename": map[string]interface{}{"id": "catalog_id"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page, merged := f.SplitAtListSteps(); page != &f || merged != nil || f.HasListSteps() {
+		t.Errorf("Pipeline without list steps should not be split")
+	}
+}
+
+func TestPipelineObjectSteps(t *testing.T) {
+	f := Value{}
+	err := f.Parse([]interface{}{
+		map[string]interface{}{"filter": map[string]interface{}{"id": "id", "name": "name", "playbook": "playbook"}},
+		map[string]interface{}{"rename": map[string]interface{}{"id": "catalog_id"}},
+		map[string]interface{}{"drop_nulls": true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := f.Apply(jsontest.Decode(t, `{"id": 1, "name": "Fred", "playbook": null, "age": 56}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 2 || result["catalog_id"] == nil || result["name"] == nil {
+		t.Errorf("Result didn't match %v", result)
+	}
+}
+
+func TestPipelineListStepOnObject(t *testing.T) {
+	f := Value{}
+	err := f.Parse([]interface{}{map[string]interface{}{"limit": 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.Apply(jsontest.Decode(t, `{"id": 1, "name": "Fred"}`))
+	if _, ok := err.(*TypeError); !ok {
+		t.Errorf("Expected a TypeError got %v", err)
+	}
+}
+
+func TestPipelineBadStep(t *testing.T) {
+	tests := []interface{}{
+		[]interface{}{},
+		[]interface{}{"results[]"},
+		[]interface{}{map[string]interface{}{"shuffle": true}},
+		[]interface{}{map[string]interface{}{"limit": "ten"}},
+		[]interface{}{map[string]interface{}{"filter": "results[].{id:id"}},
+		[]interface{}{map[string]interface{}{"sort_by": "name", "limit": 1}},
+	}
+	for _, steps := range tests {
+		f := Value{}
+		err := f.Parse(steps)
+		if err == nil {
+			t.Errorf("Parsing %v did not fail", steps)
+		}
+	}
+}
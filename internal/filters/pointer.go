@@ -0,0 +1,177 @@
+package filters
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The JSON Pointer engine projects fields out of a body. An expression is
+// either a single pointer such as /results or a JSON object whose leaves are
+// pointers, e.g. {"id":"/id","inventory":"/related/inventory"}. A string
+// filter on a list response is applied to each object in the results unless
+// its pointers start at /results.
+type pointerEngine struct{}
+
+type pointerProgram struct {
+	projection interface{}
+}
+
+// pointer is the parsed reference tokens of a JSON Pointer
+type pointer []string
+
+func (pointerEngine) Compile(expression string) (Program, error) {
+	trimmed := strings.TrimSpace(expression)
+	if strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+		var tree interface{}
+		err := json.Unmarshal([]byte(trimmed), &tree)
+		if err != nil {
+			return nil, err
+		}
+		projection, err := compileProjection(tree)
+		if err != nil {
+			return nil, err
+		}
+		return &pointerProgram{projection: projection}, nil
+	}
+	p, err := parsePointer(trimmed)
+	if err != nil {
+		return nil, err
+	}
+	return &pointerProgram{projection: p}, nil
+}
+
+func (pointerEngine) Build(element map[string]interface{}) (string, error) {
+	if _, err := compileProjection(element); err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(element)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func compileProjection(tree interface{}) (interface{}, error) {
+	switch v := tree.(type) {
+	case string:
+		return parsePointer(v)
+	case map[string]interface{}:
+		if len(v) == 0 {
+			return nil, errors.New("empty object in filter")
+		}
+		result := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			p, err := compileProjection(value)
+			if err != nil {
+				return nil, err
+			}
+			result[key] = p
+		}
+		return result, nil
+	case []interface{}:
+		if len(v) == 0 {
+			return nil, errors.New("empty list in filter")
+		}
+		result := make([]interface{}, len(v))
+		for i, value := range v {
+			p, err := compileProjection(value)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = p
+		}
+		return result, nil
+	default:
+		return nil, fmt.Errorf("unsupported filter value type %T", tree)
+	}
+}
+
+// parsePointer splits a RFC 6901 JSON Pointer into its reference tokens
+func parsePointer(s string) (pointer, error) {
+	if s == "" {
+		return pointer{}, nil
+	}
+	if !strings.HasPrefix(s, "/") {
+		return nil, fmt.Errorf("JSON pointer %q must start with /", s)
+	}
+	tokens := strings.Split(s[1:], "/")
+	for i, token := range tokens {
+		token = strings.Replace(token, "~1", "/", -1)
+		tokens[i] = strings.Replace(token, "~0", "~", -1)
+	}
+	return pointer(tokens), nil
+}
+
+func (p *pointerProgram) Search(data interface{}) (interface{}, error) {
+	return project(p.projection, data), nil
+}
+
+func (p *pointerProgram) perItem() bool {
+	return !fromResults(p.projection)
+}
+
+// fromResults checks that all the pointers of a projection start at /results
+func fromResults(projection interface{}) bool {
+	switch v := projection.(type) {
+	case pointer:
+		return len(v) > 0 && v[0] == "results"
+	case map[string]interface{}:
+		for _, value := range v {
+			if !fromResults(value) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		for _, value := range v {
+			if !fromResults(value) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func project(projection interface{}, data interface{}) interface{} {
+	switch v := projection.(type) {
+	case pointer:
+		return v.resolve(data)
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			result[key] = project(value, data)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, value := range v {
+			result[i] = project(value, data)
+		}
+		return result
+	}
+	return nil
+}
+
+// resolve the pointer, a missing value resolves to nil
+func (p pointer) resolve(data interface{}) interface{} {
+	current := data
+	for _, token := range p {
+		switch v := current.(type) {
+		case map[string]interface{}:
+			current = v[token]
+		case []interface{}:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil
+			}
+			current = v[i]
+		default:
+			return nil
+		}
+	}
+	return current
+}
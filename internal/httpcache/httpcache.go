@@ -0,0 +1,164 @@
+package httpcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMaxBytes is the default total size of the cached bodies
+const DefaultMaxBytes = 100 * 1024 * 1024
+
+// DefaultMaxEntryBytes is the default size of the largest body cached
+const DefaultMaxEntryBytes = 10 * 1024 * 1024
+
+const suffix = ".json"
+
+// Cache stores the bodies of GET responses on disk along with their
+// ETag and Last-Modified so they can be revalidated with a conditional
+// request. Entries are keyed by the URL and a fingerprint of the token
+// so a body is never served to a different user.
+type Cache struct {
+	Dir           string
+	MaxBytes      int64
+	MaxEntryBytes int64
+	mu            sync.Mutex
+}
+
+// Entry is a cached response
+type Entry struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	Body         []byte `json:"body"`
+}
+
+// New creates the cache directory if needed
+func New(dir string, maxBytes int64, maxEntryBytes int64) (*Cache, error) {
+	if dir == "" {
+		return nil, errors.New("cache directory is required")
+	}
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	if maxEntryBytes <= 0 || (maxBytes > 0 && maxEntryBytes > maxBytes) {
+		maxEntryBytes = maxBytes
+	}
+	return &Cache{Dir: dir, MaxBytes: maxBytes, MaxEntryBytes: maxEntryBytes}, nil
+}
+
+// Get returns the cached entry for the URL and token or nil
+func (c *Cache) Get(url string, token string) *Entry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fileName := c.fileName(url, token)
+	b, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil
+	}
+	var entry Entry
+	if json.Unmarshal(b, &entry) != nil || entry.URL != url {
+		os.Remove(fileName)
+		return nil
+	}
+	now := time.Now()
+	os.Chtimes(fileName, now, now)
+	return &entry
+}
+
+// SetHeaders adds the conditional request headers for the entry
+func (e *Entry) SetHeaders(h http.Header) {
+	if e.ETag != "" {
+		h.Set("If-None-Match", e.ETag)
+	}
+	if e.LastModified != "" {
+		h.Set("If-Modified-Since", e.LastModified)
+	}
+}
+
+// Put stores the body of a successful response if it has an ETag or
+// Last-Modified header and fits in the cache, the least recently used
+// entries are removed to stay under MaxBytes
+func (c *Cache) Put(url string, token string, header http.Header, body []byte) error {
+	entry := Entry{URL: url, ETag: header.Get("ETag"), LastModified: header.Get("Last-Modified"), Body: body}
+	if entry.ETag == "" && entry.LastModified == "" {
+		return nil
+	}
+	if c.MaxEntryBytes > 0 && int64(len(body)) > c.MaxEntryBytes {
+		return nil
+	}
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	tmp, err := ioutil.TempFile(c.Dir, "tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(b)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	err = os.Rename(tmp.Name(), c.fileName(url, token))
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return c.prune()
+}
+
+// prune removes the least recently used entries until the cache is
+// under MaxBytes
+func (c *Cache) prune() error {
+	if c.MaxBytes <= 0 {
+		return nil
+	}
+	files, err := ioutil.ReadDir(c.Dir)
+	if err != nil {
+		return err
+	}
+	var entries []os.FileInfo
+	var total int64
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), suffix) {
+			continue
+		}
+		entries = append(entries, f)
+		total += f.Size()
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ModTime().Before(entries[j].ModTime()) })
+	for _, f := range entries {
+		if total <= c.MaxBytes {
+			break
+		}
+		err = os.Remove(filepath.Join(c.Dir, f.Name()))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		total -= f.Size()
+	}
+	return nil
+}
+
+func (c *Cache) fileName(url string, token string) string {
+	fingerprint := sha256.Sum256([]byte(token))
+	key := sha256.Sum256([]byte(hex.EncodeToString(fingerprint[:]) + "\x00" + url))
+	return filepath.Join(c.Dir, hex.EncodeToString(key[:])+suffix)
+}
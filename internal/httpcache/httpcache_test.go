@@ -0,0 +1,87 @@
+package httpcache
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+func newCache(t *testing.T, maxBytes int64, maxEntryBytes int64) *Cache {
+	dir, err := ioutil.TempDir("", "httpcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	c, err := New(dir, maxBytes, maxEntryBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestPutGet(t *testing.T) {
+	c := newCache(t, DefaultMaxBytes, DefaultMaxEntryBytes)
+	header := http.Header{"Etag": {`"abc"`}, "Last-Modified": {"Wed, 21 Oct 2020 07:28:00 GMT"}}
+	err := c.Put("https://tower/api/v2/job_templates/", "123", header, []byte(`{"count": 1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry := c.Get("https://tower/api/v2/job_templates/", "123")
+	if entry == nil || string(entry.Body) != `{"count": 1}` {
+		t.Fatalf("Cached entry didn't match %v", entry)
+	}
+	h := http.Header{}
+	entry.SetHeaders(h)
+	if h.Get("If-None-Match") != `"abc"` || h.Get("If-Modified-Since") != "Wed, 21 Oct 2020 07:28:00 GMT" {
+		t.Errorf("Conditional headers didn't match %v", h)
+	}
+
+	if c.Get("https://tower/api/v2/job_templates/", "456") != nil {
+		t.Errorf("Entry should not be served for a different token")
+	}
+	if c.Get("https://tower/api/v2/inventories/", "123") != nil {
+		t.Errorf("Entry should not be served for a different URL")
+	}
+}
+
+func TestPutWithoutValidators(t *testing.T) {
+	c := newCache(t, DefaultMaxBytes, DefaultMaxEntryBytes)
+	err := c.Put("https://tower/api/v2/ping/", "123", http.Header{}, []byte(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Get("https://tower/api/v2/ping/", "123") != nil {
+		t.Errorf("Responses without ETag or Last-Modified should not be cached")
+	}
+}
+
+func TestSizeLimits(t *testing.T) {
+	c := newCache(t, 300, 100)
+	header := http.Header{"Etag": {`"abc"`}}
+	err := c.Put("https://tower/large", "123", header, make([]byte, 101))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Get("https://tower/large", "123") != nil {
+		t.Errorf("Entries larger than MaxEntryBytes should not be cached")
+	}
+
+	modified := time.Now().Add(-time.Hour)
+	for _, u := range []string{"https://tower/1", "https://tower/2", "https://tower/3"} {
+		err = c.Put(u, "123", header, make([]byte, 60))
+		if err != nil {
+			t.Fatal(err)
+		}
+		modified = modified.Add(time.Minute)
+		os.Chtimes(c.fileName(u, "123"), modified, modified)
+	}
+	if c.Get("https://tower/3", "123") == nil {
+		t.Errorf("Most recent entry should be kept")
+	}
+	if c.Get("https://tower/1", "123") != nil {
+		t.Errorf("Oldest entry should have been removed")
+	}
+}
@@ -0,0 +1,22 @@
+// Package jsontest decodes the JSON fixtures of the tests the way the
+// worker decodes Ansible Tower responses, with numbers as json.Number
+package jsontest
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+// Decode a JSON object, the test fails when it is not valid
+func Decode(t *testing.T, s string) map[string]interface{} {
+	t.Helper()
+	var data map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader([]byte(s)))
+	decoder.UseNumber()
+	err := decoder.Decode(&data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
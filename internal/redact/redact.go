@@ -0,0 +1,152 @@
+package redact
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Marker replaces the redacted values, it is the same marker
+// Ansible Tower uses for encrypted fields
+const Marker = "$encrypted$"
+
+// DefaultKeyPatterns match the keys whose values are always redacted. They
+// are anchored to the whole key, which may have a prefix like ssh_ or api_,
+// so keys like tokens or passwords_needed_to_start are left as is.
+var DefaultKeyPatterns = []string{
+	`^(.+_)?password$`,
+	`^(.+_)?passwd$`,
+	`^(.+_)?secret$`,
+	`^(.+_)?token$`,
+	`^(.+_)?private_key$`,
+}
+
+// relatedKey holds the links to the related objects, they are never redacted
+const relatedKey = "related"
+
+// DefaultValuePatterns match secrets embedded in string values
+var DefaultValuePatterns = []string{
+	`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`,
+	`(?i)\b(password|passwd|secret|token)=[^\s"',]+`,
+}
+
+// Redactor replaces sensitive values in a decoded JSON body
+type Redactor struct {
+	Keys   []*regexp.Regexp // Values of matching keys are redacted
+	Values []*regexp.Regexp // Parts of string values that match are redacted
+}
+
+// New builds a Redactor, the key patterns are case insensitive
+func New(keyPatterns []string, valuePatterns []string) (*Redactor, error) {
+	r := &Redactor{}
+	for _, p := range keyPatterns {
+		re, err := regexp.Compile("(?i)" + p)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid redact key pattern %q: %v", p, err)
+		}
+		r.Keys = append(r.Keys, re)
+	}
+	for _, p := range valuePatterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid redact value pattern %q: %v", p, err)
+		}
+		r.Values = append(r.Values, re)
+	}
+	return r, nil
+}
+
+// Default returns a Redactor with the DefaultKeyPatterns and DefaultValuePatterns
+func Default() *Redactor {
+	r, _ := New(DefaultKeyPatterns, DefaultValuePatterns)
+	return r
+}
+
+// Apply redacts the maps and lists in data in place, recursing into nested
+// values, and returns the number of redactions made. The related links of
+// an object are skipped.
+func (r *Redactor) Apply(data interface{}) int {
+	count := 0
+	switch v := data.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if _, ok := value.(map[string]interface{}); ok && key == relatedKey {
+				continue
+			}
+			if r.matchKey(key) && redactable(value) {
+				v[key] = Marker
+				count++
+				continue
+			}
+			v[key], count = r.value(value, count)
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i], count = r.value(value, count)
+		}
+	}
+	return count
+}
+
+func (r *Redactor) value(value interface{}, count int) (interface{}, int) {
+	if s, ok := value.(string); ok {
+		s, n := r.redactString(s)
+		return s, count + n
+	}
+	return value, count + r.Apply(value)
+}
+
+// redactString handles strings which hold serialized JSON, like the
+// extra_vars of a job, and secrets embedded in plain strings
+func (r *Redactor) redactString(s string) (string, int) {
+	trimmed := strings.TrimSpace(s)
+	if strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+		var nested interface{}
+		decoder := json.NewDecoder(strings.NewReader(trimmed))
+		decoder.UseNumber()
+		if decoder.Decode(&nested) == nil {
+			n := r.Apply(nested)
+			if n == 0 {
+				return s, 0
+			}
+			var buf bytes.Buffer
+			encoder := json.NewEncoder(&buf)
+			encoder.SetEscapeHTML(false)
+			if encoder.Encode(nested) == nil {
+				return strings.TrimSuffix(buf.String(), "\n"), n
+			}
+		}
+	}
+
+	count := 0
+	for _, re := range r.Values {
+		s = re.ReplaceAllStringFunc(s, func(string) string {
+			count++
+			return Marker
+		})
+	}
+	return s, count
+}
+
+func (r *Redactor) matchKey(key string) bool {
+	for _, re := range r.Keys {
+		if re.MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactable values are the non empty scalars, booleans are flags like
+// the secret attribute of a credential type field and are left as is
+func redactable(value interface{}) bool {
+	switch v := value.(type) {
+	case string:
+		return v != "" && v != Marker
+	case json.Number, float64, int, int64:
+		return true
+	}
+	return false
+}
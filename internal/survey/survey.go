@@ -0,0 +1,239 @@
+package survey
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SchemaVersion is the JSON Schema draft of the generated documents
+const SchemaVersion = "http://json-schema.org/draft-07/schema#"
+
+// encryptedDefault is what Ansible Tower returns as the default of a
+// password question that has one
+const encryptedDefault = "$encrypted$"
+
+// ToJSONSchema converts an Ansible Tower survey spec into a JSON Schema
+// document. Every question becomes a property named after its variable,
+// the order of the questions is kept in x-field-order.
+func ToJSONSchema(spec map[string]interface{}) (map[string]interface{}, error) {
+	questions, ok := spec["spec"].([]interface{})
+	if !ok {
+		return nil, errors.New("Survey spec does not contain a spec list")
+	}
+
+	properties := make(map[string]interface{}, len(questions))
+	required := []string{}
+	order := []string{}
+	for i, q := range questions {
+		question, ok := q.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Survey question %d is not an object", i+1)
+		}
+		variable, _ := question["variable"].(string)
+		if variable == "" {
+			return nil, fmt.Errorf("Survey question %d does not have a variable", i+1)
+		}
+		if _, found := properties[variable]; found {
+			return nil, fmt.Errorf("Survey variable %s is used by more than one question", variable)
+		}
+		property, err := convertQuestion(question)
+		if err != nil {
+			return nil, fmt.Errorf("Survey variable %s: %v", variable, err)
+		}
+		properties[variable] = property
+		order = append(order, variable)
+		if isRequired(question["required"]) {
+			required = append(required, variable)
+		}
+	}
+
+	schema := map[string]interface{}{
+		"$schema":       SchemaVersion,
+		"type":          "object",
+		"properties":    properties,
+		"required":      required,
+		"x-field-order": order,
+	}
+	if name, _ := spec["name"].(string); name != "" {
+		schema["title"] = name
+	}
+	if description, _ := spec["description"].(string); description != "" {
+		schema["description"] = description
+	}
+	return schema, nil
+}
+
+func convertQuestion(question map[string]interface{}) (map[string]interface{}, error) {
+	property := make(map[string]interface{})
+	if title, _ := question["question_name"].(string); title != "" {
+		property["title"] = title
+	}
+	if description, _ := question["question_description"].(string); description != "" {
+		property["description"] = description
+	}
+
+	questionType, _ := question["type"].(string)
+	min, err := number(question["min"])
+	if err != nil {
+		return nil, fmt.Errorf("min %v", err)
+	}
+	max, err := number(question["max"])
+	if err != nil {
+		return nil, fmt.Errorf("max %v", err)
+	}
+	def := question["default"]
+
+	switch questionType {
+	case "text", "textarea", "password":
+		property["type"] = "string"
+		setLimit(property, "minLength", min)
+		setLimit(property, "maxLength", max)
+		if questionType == "textarea" {
+			property["x-widget"] = "textarea"
+		}
+		if questionType == "password" {
+			property["format"] = "password"
+			property["writeOnly"] = true
+			if def == encryptedDefault {
+				def = nil
+			}
+		}
+		setDefault(property, stringValue(def))
+	case "integer":
+		property["type"] = "integer"
+		setLimit(property, "minimum", min)
+		setLimit(property, "maximum", max)
+		value, err := integerValue(def)
+		if err != nil {
+			return nil, fmt.Errorf("default %v", err)
+		}
+		setDefault(property, value)
+	case "float":
+		property["type"] = "number"
+		setLimit(property, "minimum", min)
+		setLimit(property, "maximum", max)
+		value, err := number(def)
+		if err != nil {
+			return nil, fmt.Errorf("default %v", err)
+		}
+		setDefault(property, value)
+	case "multiplechoice":
+		choices := listValue(question["choices"])
+		if len(choices) == 0 {
+			return nil, errors.New("multiplechoice has no choices")
+		}
+		property["type"] = "string"
+		property["enum"] = choices
+		setDefault(property, stringValue(def))
+	case "multiselect":
+		choices := listValue(question["choices"])
+		if len(choices) == 0 {
+			return nil, errors.New("multiselect has no choices")
+		}
+		property["type"] = "array"
+		property["items"] = map[string]interface{}{"type": "string", "enum": choices}
+		property["uniqueItems"] = true
+		if values := listValue(def); len(values) > 0 {
+			property["default"] = values
+		}
+	default:
+		return nil, fmt.Errorf("unknown question type %q", questionType)
+	}
+	return property, nil
+}
+
+func isRequired(v interface{}) bool {
+	switch r := v.(type) {
+	case bool:
+		return r
+	case string:
+		b, _ := strconv.ParseBool(r)
+		return b
+	}
+	return false
+}
+
+// number converts the min, max and default values which Ansible Tower
+// can return as numbers, strings or null. A missing value returns nil.
+func number(v interface{}) (interface{}, error) {
+	switch n := v.(type) {
+	case nil:
+		return nil, nil
+	case json.Number:
+		return n, nil
+	case float64:
+		return json.Number(strconv.FormatFloat(n, 'f', -1, 64)), nil
+	case int:
+		return json.Number(strconv.Itoa(n)), nil
+	case string:
+		s := strings.TrimSpace(n)
+		if s == "" {
+			return nil, nil
+		}
+		if _, err := strconv.ParseFloat(s, 64); err != nil {
+			return nil, fmt.Errorf("%q is not a number", n)
+		}
+		return json.Number(s), nil
+	}
+	return nil, fmt.Errorf("%v is not a number", v)
+}
+
+func integerValue(v interface{}) (interface{}, error) {
+	n, err := number(v)
+	if err != nil || n == nil {
+		return nil, err
+	}
+	if _, err := n.(json.Number).Int64(); err != nil {
+		return nil, fmt.Errorf("%v is not an integer", n)
+	}
+	return n, nil
+}
+
+func stringValue(v interface{}) interface{} {
+	switch s := v.(type) {
+	case string:
+		if s == "" {
+			return nil
+		}
+		return s
+	case json.Number:
+		return s.String()
+	}
+	return nil
+}
+
+// listValue splits the choices and multiselect defaults which Ansible Tower
+// stores as a newline separated string or a list
+func listValue(v interface{}) []interface{} {
+	var values []interface{}
+	switch l := v.(type) {
+	case string:
+		for _, s := range strings.Split(l, "\n") {
+			if s = strings.TrimSpace(s); s != "" {
+				values = append(values, s)
+			}
+		}
+	case []interface{}:
+		for _, item := range l {
+			if s := stringValue(item); s != nil {
+				values = append(values, s)
+			}
+		}
+	}
+	return values
+}
+
+func setLimit(property map[string]interface{}, key string, value interface{}) {
+	if value != nil {
+		property[key] = value
+	}
+}
+
+func setDefault(property map[string]interface{}, value interface{}) {
+	if value != nil {
+		property["default"] = value
+	}
+}
@@ -0,0 +1,148 @@
+package survey
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mkanoor/catalog_worker/internal/jsontest"
+)
+
+func convert(t *testing.T, question string) map[string]interface{} {
+	schema, err := ToJSONSchema(jsontest.Decode(t, `{"name": "", "description": "", "spec": [`+question+`]}`))
+	if err != nil {
+		t.Fatalf("Conversion failed %v", err)
+	}
+	properties := schema["properties"].(map[string]interface{})
+	for _, p := range properties {
+		return p.(map[string]interface{})
+	}
+	t.Fatal("No property was generated")
+	return nil
+}
+
+func TestSchemaDocument(t *testing.T) {
+	spec := jsontest.Decode(t, `{"name": "Deploy", "description": "Deploy a VM", "spec": [
+		{"variable": "username", "question_name": "User", "type": "text", "required": true, "min": 0, "max": 32, "default": ""},
+		{"variable": "size", "question_name": "Size", "type": "multiplechoice", "choices": "small\nlarge", "required": false, "default": "small"}]}`)
+
+	schema, err := ToJSONSchema(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if schema["$schema"] != SchemaVersion || schema["type"] != "object" {
+		t.Errorf("Schema header didn't match %v", schema)
+	}
+	if schema["title"] != "Deploy" || schema["description"] != "Deploy a VM" {
+		t.Errorf("Title and description didn't match %v", schema)
+	}
+	if !reflect.DeepEqual(schema["required"], []string{"username"}) {
+		t.Errorf("Required didn't match %v", schema["required"])
+	}
+	if !reflect.DeepEqual(schema["x-field-order"], []string{"username", "size"}) {
+		t.Errorf("Field order didn't match %v", schema["x-field-order"])
+	}
+}
+
+func TestText(t *testing.T) {
+	p := convert(t, `{"variable": "name", "question_name": "Name", "question_description": "Your name", "type": "text", "min": 2, "max": 20, "default": "Fred"}`)
+	expected := map[string]interface{}{
+		"type": "string", "title": "Name", "description": "Your name",
+		"minLength": json.Number("2"), "maxLength": json.Number("20"), "default": "Fred",
+	}
+	if !reflect.DeepEqual(p, expected) {
+		t.Errorf("Property didn't match %v", p)
+	}
+}
+
+func TestTextarea(t *testing.T) {
+	p := convert(t, `{"variable": "notes", "type": "textarea", "min": null, "max": null, "default": ""}`)
+	expected := map[string]interface{}{"type": "string", "x-widget": "textarea"}
+	if !reflect.DeepEqual(p, expected) {
+		t.Errorf("Property didn't match %v", p)
+	}
+}
+
+func TestPassword(t *testing.T) {
+	p := convert(t, `{"variable": "pass", "type": "password", "min": 8, "max": 64, "default": "$encrypted$"}`)
+	expected := map[string]interface{}{
+		"type": "string", "format": "password", "writeOnly": true,
+		"minLength": json.Number("8"), "maxLength": json.Number("64"),
+	}
+	if !reflect.DeepEqual(p, expected) {
+		t.Errorf("Property didn't match %v", p)
+	}
+}
+
+func TestInteger(t *testing.T) {
+	p := convert(t, `{"variable": "count", "type": "integer", "min": 1, "max": "10", "default": "3"}`)
+	expected := map[string]interface{}{
+		"type": "integer", "minimum": json.Number("1"), "maximum": json.Number("10"), "default": json.Number("3"),
+	}
+	if !reflect.DeepEqual(p, expected) {
+		t.Errorf("Property didn't match %v", p)
+	}
+}
+
+func TestFloat(t *testing.T) {
+	p := convert(t, `{"variable": "ratio", "type": "float", "min": 0.5, "max": 2.5, "default": 1.5}`)
+	expected := map[string]interface{}{
+		"type": "number", "minimum": json.Number("0.5"), "maximum": json.Number("2.5"), "default": json.Number("1.5"),
+	}
+	if !reflect.DeepEqual(p, expected) {
+		t.Errorf("Property didn't match %v", p)
+	}
+}
+
+func TestMultipleChoice(t *testing.T) {
+	p := convert(t, `{"variable": "size", "type": "multiplechoice", "choices": "small\nmedium\nlarge", "default": "medium"}`)
+	expected := map[string]interface{}{
+		"type": "string", "enum": []interface{}{"small", "medium", "large"}, "default": "medium",
+	}
+	if !reflect.DeepEqual(p, expected) {
+		t.Errorf("Property didn't match %v", p)
+	}
+}
+
+func TestMultiSelect(t *testing.T) {
+	p := convert(t, `{"variable": "zones", "type": "multiselect", "choices": ["east", "west"], "default": "east\nwest"}`)
+	expected := map[string]interface{}{
+		"type":        "array",
+		"items":       map[string]interface{}{"type": "string", "enum": []interface{}{"east", "west"}},
+		"uniqueItems": true,
+		"default":     []interface{}{"east", "west"},
+	}
+	if !reflect.DeepEqual(p, expected) {
+		t.Errorf("Property didn't match %v", p)
+	}
+}
+
+func TestRequiredAsString(t *testing.T) {
+	schema, err := ToJSONSchema(jsontest.Decode(t, `{"spec": [{"variable": "a", "type": "text", "required": "true"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(schema["required"], []string{"a"}) {
+		t.Errorf("Required didn't match %v", schema["required"])
+	}
+}
+
+func TestInvalidSpecs(t *testing.T) {
+	tests := map[string]string{
+		`{"name": "no spec"}`:          "does not contain a spec list",
+		`{"spec": ["text"]}`:           "is not an object",
+		`{"spec": [{"type": "text"}]}`: "does not have a variable",
+		`{"spec": [{"variable": "a", "type": "text"}, {"variable": "a", "type": "text"}]}`: "more than one question",
+		`{"spec": [{"variable": "a", "type": "date"}]}`:                                    "unknown question type",
+		`{"spec": [{"variable": "a", "type": "multiplechoice", "choices": ""}]}`:           "has no choices",
+		`{"spec": [{"variable": "a", "type": "integer", "min": "one"}]}`:                   "is not a number",
+		`{"spec": [{"variable": "a", "type": "integer", "default": 1.5}]}`:                 "is not an integer",
+	}
+	for spec, message := range tests {
+		_, err := ToJSONSchema(jsontest.Decode(t, spec))
+		if err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("Spec %s error %v should contain %s", spec, err, message)
+		}
+	}
+}
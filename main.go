@@ -1,23 +1,77 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
+	"github.com/mkanoor/catalog_worker/internal/artifacts"
+	"github.com/mkanoor/catalog_worker/internal/httpcache"
+	"github.com/mkanoor/catalog_worker/internal/redact"
 	log "github.com/sirupsen/logrus"
+	"gopkg.in/ini.v1"
 	"io"
+	"io/ioutil"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // CatalogConfig stores the config parameters for the
 // Catalog Worker
 type CatalogConfig struct {
-	Debug                 bool   // Enable extra logging
-	URL                   string // The URL to your Ansible Tower
-	Token                 string // The Token used to authenticate with Ansible Tower
-	SkipVerifyCertificate bool   // Skip Certifcate Validation
+	Debug                 bool             // Enable extra logging
+	URL                   string           // The URL to your Ansible Tower
+	Token                 string           // The Token used to authenticate with Ansible Tower
+	SkipVerifyCertificate bool             // Skip Certifcate Validation
+	MergePagesMaxBytes    int64            // Memory cap for merge_pages before results are sent in chunks
+	Artifacts             *artifacts.Rules // Rules for the artifacts exposed to the platform
+	Redactor              *redact.Redactor // Redacts secrets from the responses
+	Cache                 *httpcache.Cache // Optional on-disk cache for conditional GET requests
+	ShutdownGracePeriod   time.Duration    // Time the jobs have to finish after a SIGTERM or SIGINT
+	API                   *apiRoot         // API root discovered once per run, nil to use the paths as given
+	Tower                 *towerInfo       // Version of Ansible Tower detected once per run, nil to skip detection
+	Expanded              *expandCache     // Related objects fetched by expand, shared by the jobs of a request
 }
 
+// listFlag is a flag that can be repeated, the first value given
+// replaces the default values
+type listFlag struct {
+	values []string
+	set    bool
+}
+
+func (l *listFlag) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(l.values, " ")
+}
+
+func (l *listFlag) Set(value string) error {
+	if !l.set {
+		l.values = nil
+		l.set = true
+	}
+	l.values = append(l.values, value)
+	return nil
+}
+
+const defaultMergePagesMaxBytes = 10 * 1024 * 1024
+
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			err := command(os.Args[2:], os.Stdin, os.Stdout)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
 	startRun(os.Stdin, &DefaultRequestHandler{})
 }
 
@@ -46,19 +100,165 @@ func startRun(reader io.Reader, rh RequestHandler) {
 
 	log.Debug("Processing request")
 
-	rh.processRequest(req, config, &DefaultAPIWorker{})
+	ctx, stop := notifyShutdown(context.Background(), config.ShutdownGracePeriod, rh.abort)
+	defer stop()
+	rh.processRequest(ctx, req, config, &DefaultAPIWorker{})
 }
 
 func setConfig(config *CatalogConfig) {
-	flag.StringVar(&config.Token, "token", "", "Ansible Tower token")
-	flag.StringVar(&config.URL, "url", "", "Ansible Tower URL")
-	flag.BoolVar(&config.Debug, "debug", false, "log debug messages")
-	flag.BoolVar(&config.SkipVerifyCertificate, "skip_verify_ssl", false, "skip tower certificate verification")
+	err := parseConfig(flag.CommandLine, os.Args[1:], config)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// configFileKeys maps the sections and keys of the config file to the
+// command line flags. Values given on the command line take precedence.
+var configFileKeys = map[string]map[string]string{
+	ini.DefaultSection: {
+		"token":                 "token",
+		"url":                   "url",
+		"debug":                 "debug",
+		"skip_verify_ssl":       "skip_verify_ssl",
+		"merge_pages_max_bytes": "merge_pages_max_bytes",
+		"shutdown_grace_period": "shutdown_grace_period",
+	},
+	"cache": {
+		"dir":             "cache_dir",
+		"max_bytes":       "cache_max_bytes",
+		"max_entry_bytes": "cache_max_entry_bytes",
+	},
+	"artifacts": {
+		"prefixes":     "artifact_prefixes",
+		"patterns":     "artifact_patterns",
+		"keys":         "artifact_keys",
+		"max_bytes":    "max_artifacts_bytes",
+		"strip_prefix": "strip_artifact_prefix",
+		"policy":       "artifacts_policy",
+		"schema":       "artifacts_schema",
+	},
+	"redact": {
+		"keys":  "redact_keys",
+		"value": "redact_value",
+	},
+}
 
-	flag.Parse()
+func parseConfig(fs *flag.FlagSet, args []string, config *CatalogConfig) error {
+	var configFile, prefixes, patterns, keys, policy, schemaFile, redactKeys string
+	redactValues := &listFlag{values: redact.DefaultValuePatterns}
+	var cacheDir string
+	var cacheMaxBytes, cacheMaxEntryBytes int64
+	var maxArtifactsBytes int
+	var stripPrefix bool
+
+	fs.StringVar(&configFile, "config", "", "config file with the worker settings")
+	fs.StringVar(&config.Token, "token", "", "Ansible Tower token")
+	fs.StringVar(&config.URL, "url", "", "Ansible Tower URL")
+	fs.BoolVar(&config.Debug, "debug", false, "log debug messages")
+	fs.BoolVar(&config.SkipVerifyCertificate, "skip_verify_ssl", false, "skip tower certificate verification")
+	fs.Int64Var(&config.MergePagesMaxBytes, "merge_pages_max_bytes", defaultMergePagesMaxBytes, "max bytes of merged results before sending them in chunks")
+	fs.DurationVar(&config.ShutdownGracePeriod, "shutdown_grace_period", defaultShutdownGracePeriod, "time the jobs have to finish after a SIGTERM or SIGINT")
+	fs.StringVar(&prefixes, "artifact_prefixes", artifacts.ExposePrefix, "comma separated artifact key prefixes to expose")
+	fs.StringVar(&patterns, "artifact_patterns", "", "comma separated regular expressions for artifact keys to expose")
+	fs.StringVar(&keys, "artifact_keys", "", "comma separated artifact keys to expose")
+	fs.IntVar(&maxArtifactsBytes, "max_artifacts_bytes", artifacts.MaxArtifactsBytes, "max size of the exposed artifacts")
+	fs.BoolVar(&stripPrefix, "strip_artifact_prefix", false, "strip the prefix from exposed artifact keys")
+	fs.StringVar(&policy, "artifacts_policy", artifacts.PolicyFail, "fail, drop or truncate artifacts larger than max_artifacts_bytes")
+	fs.StringVar(&schemaFile, "artifacts_schema", "", "JSON schema file the exposed artifacts are validated against")
+	fs.StringVar(&redactKeys, "redact_keys", strings.Join(redact.DefaultKeyPatterns, ","), "comma separated regular expressions for keys whose values are redacted")
+	fs.Var(redactValues, "redact_value", "regular expression for secrets in values to redact, can be repeated")
+	fs.StringVar(&cacheDir, "cache_dir", "", "directory to cache GET responses in for conditional requests, disabled when empty")
+	fs.Int64Var(&cacheMaxBytes, "cache_max_bytes", httpcache.DefaultMaxBytes, "max total size of the cached responses")
+	fs.Int64Var(&cacheMaxEntryBytes, "cache_max_entry_bytes", httpcache.DefaultMaxEntryBytes, "max size of a single cached response")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if configFile != "" {
+		err = loadConfigFile(fs, configFile)
+		if err != nil {
+			return err
+		}
+	}
 	if config.Token == "" || config.URL == "" {
-		log.Fatal("Token and URL parameters are required")
+		return errors.New("Token and URL parameters are required")
+	}
+
+	config.Artifacts, err = artifacts.NewRules(splitList(prefixes), splitList(patterns), splitList(keys), maxArtifactsBytes, stripPrefix)
+	if err != nil {
+		return err
+	}
+	err = config.Artifacts.SetPolicy(policy)
+	if err != nil {
+		return err
+	}
+	if schemaFile != "" {
+		config.Artifacts.Schema, err = loadSchema(schemaFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	config.Redactor, err = redact.New(splitList(redactKeys), redactValues.values)
+	if err != nil {
+		return err
+	}
+
+	if cacheDir != "" {
+		config.Cache, err = httpcache.New(cacheDir, cacheMaxBytes, cacheMaxEntryBytes)
+	}
+	return err
+}
+
+// loadConfigFile sets the flags that were not given on the command
+// line from the ini config file. Keys can be repeated for list flags.
+func loadConfigFile(fs *flag.FlagSet, fileName string) error {
+	cfg, err := ini.LoadSources(ini.LoadOptions{AllowShadows: true}, fileName)
+	if err != nil {
+		return err
+	}
+	setFlags := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
+
+	for section, keys := range configFileKeys {
+		for key, name := range keys {
+			k, err := cfg.Section(section).GetKey(key)
+			if err != nil || setFlags[name] {
+				continue
+			}
+			for _, value := range k.ValueWithShadows() {
+				err = fs.Set(name, value)
+				if err != nil {
+					return errors.New("Invalid value for " + key + " in " + fileName + ": " + err.Error())
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func loadSchema(fileName string) (*artifacts.Schema, error) {
+	b, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	var data interface{}
+	err = json.Unmarshal(b, &data)
+	if err != nil {
+		return nil, errors.New("Error parsing " + fileName + ": " + err.Error())
+	}
+	return artifacts.ParseSchema(data)
+}
+
+func splitList(s string) []string {
+	var result []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			result = append(result, v)
+		}
 	}
+	return result
 }
 
 // Configure the logger
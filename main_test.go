@@ -2,8 +2,12 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"flag"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -23,11 +27,14 @@ func (frh *FakeRequestHandler) parseRequest(b []byte) (*RequestMessage, error) {
 	return &RequestMessage{}, nil
 }
 
-func (frh *FakeRequestHandler) processRequest(req *RequestMessage, config CatalogConfig, wh WorkHandler) {
+func (frh *FakeRequestHandler) processRequest(ctx context.Context, req *RequestMessage, config CatalogConfig, wh WorkHandler) {
 	frh.timesCalled++
 	frh.catalogConfig = config
 }
 
+func (frh *FakeRequestHandler) abort() {
+}
+
 func TestMain(t *testing.T) {
 	os.Args = []string{"catalog_worker",
 		"--debug",
@@ -50,3 +57,69 @@ func TestMain(t *testing.T) {
 		t.Errorf("Token has not been set")
 	}
 }
+
+func TestParseConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "catalog_worker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "catalog_worker.ini")
+	data := []byte(`token = from_file
+url = https://tower.example.com
+skip_verify_ssl = true
+
+[artifacts]
+prefixes = catalog_, expose_to_cloud_redhat_com_
+keys = hostname
+max_bytes = 2048
+strip_prefix = true
+
+[cache]
+dir = ` + filepath.Join(dir, "cache") + `
+max_bytes = 4096
+
+[redact]
+keys = password, ^vault_
+value = \d{3}-\d{2}-\d{4}
+value = (?i)apikey=\S+
+`)
+	err = ioutil.WriteFile(fileName, data, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := CatalogConfig{}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	err = parseConfig(fs, []string{"--config", fileName, "--token", "from_flag"}, &config)
+	if err != nil {
+		t.Fatalf("Error parsing config %v", err)
+	}
+	if config.Token != "from_flag" {
+		t.Errorf("Command line token should override the config file")
+	}
+	if config.URL != "https://tower.example.com" || !config.SkipVerifyCertificate {
+		t.Errorf("Config file values have not been set")
+	}
+	if len(config.Artifacts.Prefixes) != 2 || config.Artifacts.MaxBytes != 2048 || !config.Artifacts.StripPrefix {
+		t.Errorf("Artifact rules have not been set %v", config.Artifacts)
+	}
+	if len(config.Artifacts.AllowKeys) != 1 || config.Artifacts.AllowKeys[0] != "hostname" {
+		t.Errorf("Artifact keys have not been set")
+	}
+	if len(config.Redactor.Keys) != 2 || len(config.Redactor.Values) != 2 {
+		t.Errorf("Redact patterns have not been set %v", config.Redactor)
+	}
+	if config.Cache == nil || config.Cache.MaxBytes != 4096 {
+		t.Errorf("Cache has not been set %v", config.Cache)
+	}
+}
+
+func TestParseConfigMissingToken(t *testing.T) {
+	config := CatalogConfig{}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	err := parseConfig(fs, []string{"--url", "https://tower.example.com"}, &config)
+	if err == nil {
+		t.Error("Missing token did not fail")
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const meSlug = "/api/v2/me/"
+
+// ping checks that the worker can use Ansible Tower with its config and
+// sends a diagnostic report. Each check records whether it passed, the
+// checks that depend on a failed one are skipped.
+func (w *WorkUnit) ping() error {
+	checks := make(map[string]interface{})
+	report := map[string]interface{}{"url": w.config.URL, "api_prefix": w.towerPath(defaultAPIPrefix), "checks": checks}
+	ok := w.pingConnectivity(checks) && w.pingToken(checks)
+	report["ok"] = ok
+	return w.writeObject(w.input.HrefSlug, report, 200)
+}
+
+// pingConnectivity checks that the ping endpoint can be reached over TLS
+// and records the round trip latency
+func (w *WorkUnit) pingConnectivity(checks map[string]interface{}) bool {
+	tlsCheck := map[string]interface{}{"skip_verify": w.config.SkipVerifyCertificate}
+	connectivity := make(map[string]interface{})
+	checks["connectivity"] = connectivity
+	if strings.HasPrefix(w.config.URL, "https:") {
+		checks["tls"] = tlsCheck
+	}
+
+	start := time.Now()
+	jsonBody, resp, err := w.pingGet(w.input.HrefSlug)
+	connectivity["latency_ms"] = time.Since(start).Milliseconds()
+	if err != nil && resp == nil {
+		connectivity["ok"] = false
+		connectivity["error"] = err.Error()
+		if isCertificateError(err) {
+			tlsCheck["ok"] = false
+			tlsCheck["error"] = err.Error()
+		}
+		return false
+	}
+	connectivity["status"] = resp.StatusCode
+	if resp.TLS != nil {
+		tlsCheck["ok"] = true
+		if len(resp.TLS.PeerCertificates) > 0 {
+			tlsCheck["expires"] = resp.TLS.PeerCertificates[0].NotAfter.UTC().Format(time.RFC3339)
+		}
+	}
+	if err != nil {
+		connectivity["ok"] = false
+		connectivity["error"] = err.Error()
+		return false
+	}
+	connectivity["ok"] = true
+	if version, ok := jsonBody["version"].(string); ok {
+		connectivity["version"] = version
+	}
+	return true
+}
+
+// pingToken checks the token with the me endpoint and records the user,
+// its role and organizations
+func (w *WorkUnit) pingToken(checks map[string]interface{}) bool {
+	token := make(map[string]interface{})
+	checks["token"] = token
+	jsonBody, resp, err := w.pingGet(meSlug)
+	if err != nil {
+		token["ok"] = false
+		token["error"] = err.Error()
+		if resp != nil && (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) {
+			token["error"] = "Token is invalid or expired: " + resp.Status
+		}
+		return false
+	}
+	results, _ := jsonBody["results"].([]interface{})
+	if len(results) == 0 {
+		token["ok"] = false
+		token["error"] = "No user returned for the token"
+		return false
+	}
+	user, _ := results[0].(map[string]interface{})
+	token["ok"] = true
+
+	permissions := make(map[string]interface{})
+	checks["permissions"] = permissions
+	permissions["username"] = user["username"]
+	superuser, _ := user["is_superuser"].(bool)
+	auditor, _ := user["is_system_auditor"].(bool)
+	permissions["is_superuser"] = superuser
+	permissions["is_system_auditor"] = auditor
+	switch {
+	case superuser:
+		permissions["role"] = "admin"
+	case auditor:
+		permissions["role"] = "auditor"
+	default:
+		permissions["role"] = "user"
+	}
+
+	id, ok := user["id"].(json.Number)
+	if !ok {
+		permissions["ok"] = false
+		permissions["error"] = "User has no id"
+		return false
+	}
+	orgs, _, err := w.pingGet(fmt.Sprintf("%susers/%s/organizations/", defaultAPIPrefix, id))
+	if err != nil {
+		permissions["ok"] = false
+		permissions["error"] = "Error fetching organizations: " + err.Error()
+		return false
+	}
+	names := []interface{}{}
+	orgResults, _ := orgs["results"].([]interface{})
+	for _, r := range orgResults {
+		if org, ok := r.(map[string]interface{}); ok {
+			names = append(names, org["name"])
+		}
+	}
+	permissions["organizations"] = names
+	permissions["ok"] = true
+	return true
+}
+
+// pingGet does a GET for a path, the response is returned along with the
+// error when Ansible Tower responds with a failure status
+func (w *WorkUnit) pingGet(path string) (map[string]interface{}, *http.Response, error) {
+	sub, err := w.subWork(JobParam{Method: "get", HrefSlug: path})
+	if err != nil {
+		return nil, nil, err
+	}
+	body, resp, err := sub.fetchPage()
+	if err != nil {
+		return nil, nil, err
+	}
+	if !successHTTPCode(resp.StatusCode) {
+		return nil, resp, errors.New("HTTP GET call failed with " + resp.Status)
+	}
+	jsonBody, err := decodeJSON(body)
+	if err != nil {
+		log.Error(err)
+		return nil, resp, err
+	}
+	return jsonBody, resp, nil
+}
+
+func isCertificateError(err error) bool {
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	return errors.As(err, &unknownAuthority) || errors.As(err, &hostname) || errors.As(err, &invalid) ||
+		strings.Contains(err.Error(), "tls:")
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// idsOnlyFilter trims every page down to the fields needed to detect
+// added, changed and removed objects
+const idsOnlyFilter = "results[].{id:id, modified:modified}"
+
+// Params used by reconcile, they are not sent to Ansible Tower
+const (
+	knownIDsParam      = "known_ids"
+	modifiedSinceParam = "modified_since"
+)
+
+// idsOnly fetches all the pages of the endpoint keeping only the id and
+// modified of each object and sends them as a single response
+func (w *WorkUnit) idsOnly() error {
+	objects, status, err := w.listIDs(w.input.Params)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+
+	ids := sortedIDs(boolSet(objects))
+	results := make([]interface{}, len(ids))
+	for i, id := range ids {
+		results[i] = map[string]interface{}{"id": id, "modified": objects[id]}
+	}
+	body := map[string]interface{}{
+		"count":    len(results),
+		"next":     nil,
+		"previous": nil,
+		"results":  results,
+	}
+	return w.writePage(body, status)
+}
+
+// reconcile compares the objects in Ansible Tower with the ids known to
+// the platform and reports the ids that were added, changed and removed.
+// known_ids is either a list of ids, in which case an object is changed
+// when it was modified after modified_since, or a map of id to modified.
+func (w *WorkUnit) reconcile() error {
+	known, since, err := parseKnownIDs(w.input.Params)
+	if err != nil {
+		w.sendError(err.Error(), 0)
+		log.Error(err)
+		return err
+	}
+
+	params := copyParams(w.input.Params)
+	delete(params, knownIDsParam)
+	delete(params, modifiedSinceParam)
+	objects, status, err := w.listIDs(params)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+
+	added, changed, removed := []int64{}, []int64{}, []int64{}
+	for _, id := range sortedIDs(boolSet(objects)) {
+		modified, ok := known[id]
+		switch {
+		case !ok:
+			added = append(added, id)
+		case modified != "":
+			if modified != objects[id] {
+				changed = append(changed, id)
+			}
+		case !since.IsZero():
+			if t, err := time.Parse(time.RFC3339Nano, objects[id]); err != nil || t.After(since) {
+				changed = append(changed, id)
+			}
+		}
+	}
+	for id := range known {
+		if _, ok := objects[id]; !ok {
+			removed = append(removed, id)
+		}
+	}
+	sort.Slice(removed, func(i, j int) bool { return removed[i] < removed[j] })
+
+	body := map[string]interface{}{
+		"count":   len(objects),
+		"added":   added,
+		"changed": changed,
+		"removed": removed,
+	}
+	return w.writePage(body, status)
+}
+
+// listIDs fetches all the pages of the endpoint and returns the modified
+// timestamp of every object keyed by its id
+func (w *WorkUnit) listIDs(params map[string]interface{}) (map[int64]string, int, error) {
+	params = copyParams(params)
+	if _, ok := params["page_size"]; !ok {
+		params["page_size"] = strconv.Itoa(maxBatchSize)
+	}
+	delete(params, "page")
+	sub, err := w.subWork(JobParam{
+		Method:        "get",
+		HrefSlug:      w.input.HrefSlug,
+		FetchAllPages: true,
+		ApplyFilter:   idsOnlyFilter,
+		Params:        params,
+	})
+	if err != nil {
+		w.sendError(err.Error(), 0)
+		return nil, 0, err
+	}
+
+	objects := make(map[int64]string)
+	status := 0
+	err = sub.eachPage(func(jsonBody map[string]interface{}, httpStatus int) error {
+		status = httpStatus
+		results, _ := jsonBody["results"].([]interface{})
+		for _, r := range results {
+			object, ok := r.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			n, ok := object["id"].(json.Number)
+			if !ok {
+				continue
+			}
+			id, err := n.Int64()
+			if err != nil {
+				continue
+			}
+			modified, _ := object["modified"].(string)
+			objects[id] = modified
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return objects, status, nil
+}
+
+// parseKnownIDs returns the known ids with their modified timestamps, which
+// are empty when known_ids is a list, and the optional modified_since time
+func parseKnownIDs(params map[string]interface{}) (map[int64]string, time.Time, error) {
+	var since time.Time
+	known := make(map[int64]string)
+
+	switch ids := params[knownIDsParam].(type) {
+	case []interface{}:
+		for _, v := range ids {
+			id, err := toID(v)
+			if err != nil {
+				return nil, since, err
+			}
+			known[id] = ""
+		}
+	case map[string]interface{}:
+		for k, v := range ids {
+			id, err := toID(k)
+			if err != nil {
+				return nil, since, err
+			}
+			modified, ok := v.(string)
+			if !ok {
+				return nil, since, fmt.Errorf("%s %s has an invalid modified %v", knownIDsParam, k, v)
+			}
+			known[id] = modified
+		}
+	case nil:
+		return nil, since, errors.New("reconcile requires " + knownIDsParam + " in params")
+	default:
+		return nil, since, fmt.Errorf("%s should be a list of ids or a map of id to modified, got %T", knownIDsParam, ids)
+	}
+
+	if v, ok := params[modifiedSinceParam]; ok {
+		s, ok := v.(string)
+		if !ok {
+			return nil, since, fmt.Errorf("%s should be a string, got %T", modifiedSinceParam, v)
+		}
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return nil, since, fmt.Errorf("Invalid %s %s: %v", modifiedSinceParam, s, err)
+		}
+		since = t
+	}
+	return known, since, nil
+}
+
+func toID(v interface{}) (int64, error) {
+	switch id := v.(type) {
+	case json.Number:
+		return id.Int64()
+	case float64:
+		return int64(id), nil
+	case int64:
+		return id, nil
+	case int:
+		return int64(id), nil
+	case string:
+		n, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("Invalid id %s in %s", id, knownIDsParam)
+		}
+		return n, nil
+	}
+	return 0, fmt.Errorf("Invalid id %v in %s", v, knownIDsParam)
+}
+
+func boolSet(objects map[int64]string) map[int64]bool {
+	result := make(map[int64]bool, len(objects))
+	for id := range objects {
+		result[id] = true
+	}
+	return result
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	log "github.com/sirupsen/logrus"
 	"io"
@@ -16,10 +17,22 @@ type JobParam struct {
 	Method                 string                 `json:"method"`
 	HrefSlug               string                 `json:"href_slug"`
 	FetchAllPages          bool                   `json:"fetch_all_pages"`
+	MergePages             bool                   `json:"merge_pages"`
 	Params                 map[string]interface{} `json:"params"`
 	AcceptEncoding         string                 `json:"accept_encoding"`
 	ApplyFilter            interface{}            `json:"apply_filter"`
+	FilterResultKey        string                 `json:"filter_result_key"`
+	FilterLanguage         string                 `json:"filter_language"`
 	RefreshIntervalSeconds int64                  `json:"refresh_interval_seconds"`
+	JobIDs                 []int64                `json:"job_ids"`
+	ArtifactsSchema        map[string]interface{} `json:"artifacts_schema"`
+	SurveyJSONSchema       bool                   `json:"survey_json_schema"`
+	Transform              string                 `json:"transform"`
+	Expand                 []string               `json:"expand"`
+	ExpandFilter           map[string]interface{} `json:"expand_filter"`
+	ContentHash            bool                   `json:"content_hash"`
+	MinVersion             string                 `json:"min_version"`
+	KnownHashes            map[string]string      `json:"known_hashes"`
 }
 
 // PayloadStruct contains a collection of JobParam
@@ -34,17 +47,34 @@ type RequestMessage struct {
 	Sender    string        `json:"sender"`
 	MessageID string        `json:"message_id"`
 	Payload   PayloadStruct `json:"payload"`
+
+	invalidJobs []jobError
+}
+
+// rawRequest is the RequestMessage before the jobs are validated
+type rawRequest struct {
+	Account   string `json:"account"`
+	Sender    string `json:"sender"`
+	MessageID string `json:"message_id"`
+	Payload   struct {
+		Jobs []json.RawMessage `json:"jobs"`
+	} `json:"payload"`
 }
 
 // RequestHandler interface allows for easy mocking during testing
 type RequestHandler interface {
 	getRequest(r io.Reader) ([]byte, error)
 	parseRequest(b []byte) (*RequestMessage, error)
-	processRequest(req *RequestMessage, config CatalogConfig, wh WorkHandler)
+	processRequest(ctx context.Context, req *RequestMessage, config CatalogConfig, wh WorkHandler)
+	abort()
 }
 
-// DefaultRequestHandler implements the 3 RequestHandler methods
+// DefaultRequestHandler implements the RequestHandler methods
 type DefaultRequestHandler struct {
+	Output io.Writer // Where the responses are written, stdout when nil
+
+	mu        sync.Mutex
+	responder *Responder
 }
 
 // getRequest get data from the Receptor via Stdin
@@ -64,58 +94,121 @@ func (drh *DefaultRequestHandler) getRequest(ior io.Reader) ([]byte, error) {
 
 // Parse the request into RequestMessage
 func (drh *DefaultRequestHandler) parseRequest(b []byte) (*RequestMessage, error) {
-	req := RequestMessage{}
+	raw := rawRequest{}
 	decoder := json.NewDecoder(bytes.NewReader(b))
 	decoder.UseNumber()
-	err := decoder.Decode(&req)
+	err := decoder.Decode(&raw)
 	if err != nil {
 		log.Errorf("Error decoding json %v", err)
 		return nil, err
 	}
+	if raw.MessageID == "" {
+		log.Warn("Request has no message_id")
+	}
+
+	req := RequestMessage{Account: raw.Account, Sender: raw.Sender, MessageID: raw.MessageID}
+	req.Payload.Jobs, req.invalidJobs = parseJobs(raw.Payload.Jobs)
+	for i := range req.invalidJobs {
+		log.Errorf("Invalid request %v", &req.invalidJobs[i])
+	}
 	return &req, nil
 }
 
 // Process the incoming request, start a go routine responder that
 // can send ack responses to the receptor. Then for each of the JobParam
-// start a go routine to do the work.
-func (drh *DefaultRequestHandler) processRequest(req *RequestMessage, config CatalogConfig, wh WorkHandler) {
+// start a go routine to do the work. When the context is cancelled the
+// workers stop and the eof is sent with the cancelled code.
+func (drh *DefaultRequestHandler) processRequest(ctx context.Context, req *RequestMessage, config CatalogConfig, wh WorkHandler) {
 	var workerGroup sync.WaitGroup
 	var responderGroup sync.WaitGroup
 	outputChannel := make(chan ResponsePayload)
+	output := drh.Output
+	if output == nil {
+		output = os.Stdout
+	}
 	rs := &Responder{
-		Output: os.Stdout,
+		Output: output,
 		header: ResponseHeader{
 			Account:      req.Account,
 			Sender:       req.Sender,
 			InResponseTo: req.MessageID,
 		},
 	}
+	drh.mu.Lock()
+	drh.responder = rs
+	drh.mu.Unlock()
 	responderGroup.Add(1)
 	log.Debug("Starting Responder")
 	go startResponder(&responderGroup, rs, outputChannel)
 
+	for i := range req.invalidJobs {
+		je := &req.invalidJobs[i]
+		outputChannel <- ResponsePayload{messageType: "data", code: 1, data: ResponseData{HrefSlug: je.hrefSlug, Body: je.Error()}}
+	}
+
+	config.API = &apiRoot{}
+	config.Tower = &towerInfo{}
+	config.Expanded = &expandCache{}
 	log.Debug("Starting Workers")
-	req.dispatch(config, &workerGroup, wh, outputChannel)
+	req.dispatch(ctx, config, &workerGroup, wh, outputChannel)
 
 	workerGroup.Wait()
-	outputChannel <- ResponsePayload{messageType: "eof"}
+	pl := eofPayload(&config)
+	if ctx.Err() != nil {
+		log.Warn("Request was cancelled")
+		pl.code = cancelledCode
+	}
+	outputChannel <- pl
 	responderGroup.Wait()
 }
 
+// abort sends the cancelled eof when the jobs didn't stop in time and the
+// process is about to exit, the responses the jobs send after it are dropped
+func (drh *DefaultRequestHandler) abort() {
+	drh.mu.Lock()
+	rs := drh.responder
+	drh.mu.Unlock()
+	if rs == nil {
+		return
+	}
+	err := rs.send(&ResponsePayload{messageType: "eof", code: cancelledCode})
+	if err != nil {
+		log.Errorf("Error sending the cancelled eof %v", err)
+	}
+}
+
+// eofPayload builds the eof message with a summary of the Ansible Tower
+// the jobs ran against
+func eofPayload(config *CatalogConfig) ResponsePayload {
+	pl := ResponsePayload{messageType: "eof"}
+	if config.Tower == nil {
+		return pl
+	}
+	if tower := config.Tower.summary(); tower != nil {
+		b, err := json.Marshal(map[string]interface{}{"tower": tower})
+		if err != nil {
+			log.Error(err)
+			return pl
+		}
+		pl.data.Body = string(b)
+	}
+	return pl
+}
+
 // Foreach of the JobParam in the payload we can start a go routine
 // to handle the request independently
-func (req *RequestMessage) dispatch(config CatalogConfig, workerGroup *sync.WaitGroup, wh WorkHandler, outputChannel chan ResponsePayload) {
+func (req *RequestMessage) dispatch(ctx context.Context, config CatalogConfig, workerGroup *sync.WaitGroup, wh WorkHandler, outputChannel chan ResponsePayload) {
 	for _, v := range req.Payload.Jobs {
 		workerGroup.Add(1)
 		log.Debugf("Job Input Data %v", v)
-		go startWorker(config, workerGroup, wh, outputChannel, v)
+		go startWorker(ctx, config, workerGroup, wh, outputChannel, v)
 	}
 }
 
 // Start a work
-func startWorker(config CatalogConfig, wg *sync.WaitGroup, wh WorkHandler, outputChannel chan ResponsePayload, params JobParam) {
+func startWorker(ctx context.Context, config CatalogConfig, wg *sync.WaitGroup, wh WorkHandler, outputChannel chan ResponsePayload, params JobParam) {
 	log.Debugf("Worker starting")
 	defer log.Debugf("Worker finished")
 	defer wg.Done()
-	wh.StartWork(&config, params, nil, outputChannel)
+	wh.StartWork(ctx, &config, params, nil, outputChannel)
 }
@@ -2,8 +2,10 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"net/http"
 	"os"
+	"strings"
 	"testing"
 
 	log "github.com/sirupsen/logrus"
@@ -44,7 +46,7 @@ type FakeHandler struct {
 	timesCalled int
 }
 
-func (fh *FakeHandler) StartWork(config *CatalogConfig, params JobParam, client *http.Client, channel chan ResponsePayload) error {
+func (fh *FakeHandler) StartWork(ctx context.Context, config *CatalogConfig, params JobParam, client *http.Client, channel chan ResponsePayload) error {
 	fh.timesCalled++
 	return nil
 }
@@ -58,8 +60,115 @@ func TestProcessRequest(t *testing.T) {
 		t.Fatalf("Error parsing request data %v", err)
 	}
 	fh := FakeHandler{}
-	drh.processRequest(req, CatalogConfig{}, &fh)
+	drh.processRequest(context.Background(), req, CatalogConfig{}, &fh)
 	if fh.timesCalled != 2 {
 		t.Fatalf("2 workers should have been started only %d were started", fh.timesCalled)
 	}
 }
+
+func TestEOFPayload(t *testing.T) {
+	pl := eofPayload(&CatalogConfig{})
+	if pl.messageType != "eof" || pl.data.Body != "" {
+		t.Errorf("eof without a tower summary didn't match %v", pl)
+	}
+
+	tower := &towerInfo{version: "3.7.3", licenseType: "enterprise", instanceGroups: []string{"tower"}}
+	pl = eofPayload(&CatalogConfig{Tower: tower})
+	expected := `{"tower":{"instance_groups":["tower"],"license_type":"enterprise","version":"3.7.3"}}`
+	if pl.messageType != "eof" || pl.data.Body != expected {
+		t.Errorf("eof summary didn't match %s", pl.data.Body)
+	}
+}
+
+func TestParseRequestInvalidJobs(t *testing.T) {
+	b := []byte(`{"account":"12345","sender":"buzz", "message_id":"4567","payload":{"jobs": [
+		{"method":"get","href_slug":"/api/v2/job_templates","accept-encoding":"gzip"},
+		{"method":"get","href_slug":"/api/v2/credentials","fetch_all_pages":"true","job_ids":[1.5]},
+		{"method":"delete","href_slug":"/api/v2/jobs/1"},
+		{"method":"get","href_slug":"/api/v2/job_templates/5/?page=1","survey_json_schema":true},
+		{"method":"get","href_slug":"/api/v2/hosts/","fetch_all_pages":true,"apply_filter":[{"sort_by":"name"},{"limit":5}]},
+		{"method":"get","href_slug":"/api/v2/inventories"}]}}`)
+	log.SetOutput(os.Stdout)
+	drh := &DefaultRequestHandler{}
+	req, err := drh.parseRequest(b)
+	if err != nil {
+		t.Fatalf("Error parsing request data %v", err)
+	}
+	if len(req.Payload.Jobs) != 1 || req.Payload.Jobs[0].HrefSlug != "/api/v2/inventories" {
+		t.Fatalf("Only the valid job should be run %v", req.Payload.Jobs)
+	}
+	expected := []string{
+		"job 1: unknown key accept-encoding, did you mean accept_encoding?",
+		"job 2: fetch_all_pages should be a boolean, got string; job_ids should be a list of integers, got number 1.5",
+		"job 3: method delete should be one of get, post, monitor, monitor_batch, catalog_sync, ids_only, reconcile, ping",
+		"job 4: survey_json_schema can only be used with a survey_spec/ href_slug",
+		"job 5: sort_by, limit and dedupe work on a single page, use merge_pages with fetch_all_pages",
+	}
+	errs := validateRequest(req)
+	if len(errs) != len(expected) {
+		t.Fatalf("Expected %d errors got %v", len(expected), errs)
+	}
+	for i, err := range errs {
+		if err.Error() != expected[i] {
+			t.Errorf("Error didn't match %s", err)
+		}
+	}
+}
+
+func TestParseRequestMissingMessageID(t *testing.T) {
+	drh := &DefaultRequestHandler{}
+	req, err := drh.parseRequest([]byte(`{"account":"12345","sender":"buzz","payload":{"jobs": [{"method":"get","href_slug":"/api/v2/inventories/899"}]}}`))
+	if err != nil {
+		t.Fatalf("Request without a message_id should still be run %v", err)
+	}
+	if len(req.Payload.Jobs) != 1 {
+		t.Fatalf("The job should be run %v", req.Payload.Jobs)
+	}
+	errs := validateRequest(req)
+	if len(errs) != 1 || errs[0].Error() != "message_id is required" {
+		t.Errorf("Validate should report the missing message_id %v", errs)
+	}
+
+	var out bytes.Buffer
+	drh.Output = &out
+	drh.processRequest(context.Background(), req, CatalogConfig{}, &FakeHandler{})
+	if !strings.Contains(out.String(), `"message_type":"eof"`) {
+		t.Errorf("An eof should have been sent %s", out.String())
+	}
+}
+
+func TestProcessRequestInvalidJobs(t *testing.T) {
+	b := []byte(`{"account":"12345","sender":"buzz", "message_id":"4567","payload":{"jobs": [{"method":"get"},{"method":"get","href_slug":"/api/v2/inventories/899"}]}}`)
+	log.SetOutput(os.Stdout)
+	var out bytes.Buffer
+	drh := &DefaultRequestHandler{Output: &out}
+	req, err := drh.parseRequest(b)
+	if err != nil {
+		t.Fatalf("Error parsing request data %v", err)
+	}
+	fh := FakeHandler{}
+	drh.processRequest(context.Background(), req, CatalogConfig{}, &fh)
+	if fh.timesCalled != 1 {
+		t.Fatalf("1 worker should have been started, %d were started", fh.timesCalled)
+	}
+	if !strings.Contains(out.String(), `"body":"job 1: href_slug is required","status":0},"code":1`) {
+		t.Errorf("Missing error response %s", out.String())
+	}
+}
+
+func TestProcessRequestCancelled(t *testing.T) {
+	b := []byte(`{"account":"12345","sender":"buzz", "message_id":"4567","payload":{"jobs": [{"method":"get","href_slug":"/api/v2/inventories/899"}]}}`)
+	log.SetOutput(os.Stdout)
+	var out bytes.Buffer
+	drh := &DefaultRequestHandler{Output: &out}
+	req, err := drh.parseRequest(b)
+	if err != nil {
+		t.Fatalf("Error parsing request data %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	drh.processRequest(ctx, req, CatalogConfig{}, &FakeHandler{})
+	if !strings.Contains(out.String(), `"message_type":"eof"`) || !strings.Contains(out.String(), `"code":2`) {
+		t.Errorf("Missing cancelled eof %s", out.String())
+	}
+}
@@ -17,6 +17,10 @@ type ResponseData struct {
 	Status   int    `json:"status"`
 }
 
+// cancelledCode is the code of the eof when the request was cancelled
+// before all the jobs finished
+const cancelledCode = 2
+
 // ResponsePayload is the internal struct to exchange data between the
 // go routines (start worker to the responder)
 type ResponsePayload struct {
@@ -51,6 +55,13 @@ type Responder struct {
 	Output       io.Writer
 	messageCount int
 	header       ResponseHeader
+	mu           sync.Mutex
+	finished     bool
+}
+
+// flusher is an Output that buffers the responses
+type flusher interface {
+	Flush() error
 }
 
 // start the Responder as a go routine. It waits for messages coming from the
@@ -63,16 +74,10 @@ func startResponder(wg *sync.WaitGroup, rs *Responder, channel chan ResponsePayl
 	for {
 		pl := <-channel
 		log.Info("Read data from channel")
-		str, err := rs.createResponse(&pl)
+		err := rs.send(&pl)
 		if err != nil {
-			log.Fatalf("Error creating response %v", err)
+			log.Fatal(err)
 		}
-
-		n, err := fmt.Fprintf(rs.Output, "%s\n", str)
-		if err != nil {
-			log.Fatalf("Error writing response %v", err)
-		}
-		log.Infof("Number of bytes written %d", n)
 		if pl.messageType == "eof" {
 			break
 		}
@@ -80,6 +85,35 @@ func startResponder(wg *sync.WaitGroup, rs *Responder, channel chan ResponsePayl
 	log.Info("Finished Responder")
 }
 
+// send writes a response and flushes the Output. Nothing is written after
+// the eof, the abort and the responder go routine can both send one.
+func (r *Responder) send(pl *ResponsePayload) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.finished {
+		log.Infof("Dropping %s response sent after the eof", pl.messageType)
+		return nil
+	}
+	str, err := r.createResponse(pl)
+	if err != nil {
+		return fmt.Errorf("Error creating response %v", err)
+	}
+
+	n, err := fmt.Fprintf(r.Output, "%s\n", str)
+	if err != nil {
+		return fmt.Errorf("Error writing response %v", err)
+	}
+	log.Infof("Number of bytes written %d", n)
+	if f, ok := r.Output.(flusher); ok {
+		err = f.Flush()
+		if err != nil {
+			return fmt.Errorf("Error flushing response %v", err)
+		}
+	}
+	r.finished = pl.messageType == "eof"
+	return nil
+}
+
 // createResponse builds a response payload that can be sent to the
 // Receptor by the responder go routine.
 func (r *Responder) createResponse(pl *ResponsePayload) (string, error) {
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const defaultShutdownGracePeriod = 10 * time.Second
+
+// exit is replaced in the tests
+var exit = os.Exit
+
+// notifyShutdown returns a context that is cancelled when the Receptor
+// sends SIGTERM or SIGINT. If the work doesn't finish within the grace
+// period after the signal abort is called and the process exits. stop
+// has to be called once the work is done.
+func notifyShutdown(parent context.Context, grace time.Duration, abort func()) (ctx context.Context, stop func()) {
+	ctx, cancel := context.WithCancel(parent)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	done := make(chan struct{})
+
+	go func() {
+		select {
+		case sig := <-signals:
+			log.Warnf("Received %v, cancelling the jobs", sig)
+			cancel()
+		case <-done:
+			return
+		}
+		select {
+		case <-time.After(grace):
+			log.Errorf("Jobs did not finish within %v of the signal, exiting", grace)
+			abort()
+			exit(1)
+		case <-done:
+		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(signals)
+		close(done)
+		cancel()
+	}
+}
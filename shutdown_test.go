@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// hangingHandler is a job that ignores the cancellation
+type hangingHandler struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (h *hangingHandler) StartWork(ctx context.Context, config *CatalogConfig, params JobParam, client *http.Client, channel chan ResponsePayload) error {
+	close(h.started)
+	<-h.release
+	channel <- ResponsePayload{messageType: "data", data: ResponseData{Body: "late"}}
+	return nil
+}
+
+func TestNotifyShutdown(t *testing.T) {
+	exited := make(chan int, 1)
+	exit = func(code int) { exited <- code }
+	defer func() { exit = os.Exit }()
+
+	var out bytes.Buffer
+	drh := &DefaultRequestHandler{Output: &out}
+	req := &RequestMessage{MessageID: "4567", Payload: PayloadStruct{Jobs: []JobParam{{Method: "get", HrefSlug: "/api/v2/jobs/1/"}}}}
+	wh := &hangingHandler{started: make(chan struct{}), release: make(chan struct{})}
+	ctx, stop := notifyShutdown(context.Background(), 10*time.Millisecond, drh.abort)
+	processed := make(chan struct{})
+	go func() {
+		drh.processRequest(ctx, req, CatalogConfig{}, wh)
+		close(processed)
+	}()
+	<-wh.started
+
+	err := syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Context was not cancelled by the signal")
+	}
+	select {
+	case code := <-exited:
+		if code != 1 {
+			t.Errorf("Expected exit code 1, got %d", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Did not exit after the grace period")
+	}
+
+	close(wh.release)
+	<-processed
+	stop()
+	var resp ResponseMessage
+	err = json.Unmarshal(out.Bytes(), &resp)
+	if err != nil {
+		t.Fatalf("Output should be a single response %v: %s", err, out.String())
+	}
+	if resp.MessageType != "eof" || resp.Code != cancelledCode || resp.InResponseTo != "4567" {
+		t.Errorf("Expected a cancelled eof %s", out.String())
+	}
+}
+
+func TestNotifyShutdownStopped(t *testing.T) {
+	ctx, stop := notifyShutdown(context.Background(), time.Second, func() {})
+	if ctx.Err() != nil {
+		t.Fatalf("Context should not be cancelled before a signal")
+	}
+	stop()
+	if ctx.Err() == nil {
+		t.Errorf("Context should be cancelled when stopped")
+	}
+}
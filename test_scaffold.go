@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"io"
@@ -90,7 +91,7 @@ func (ts *testScaffold) runSuccess(t *testing.T, jp JobParam, responseCode int,
 	ts.base(t, jp, responseCode, responseBody)
 	ts.responses = responses
 	apiw := &DefaultAPIWorker{}
-	err := apiw.StartWork(ts.config, jp, ts.client, ts.outputChannel)
+	err := apiw.StartWork(context.Background(), ts.config, jp, ts.client, ts.outputChannel)
 	if err != nil {
 		t.Fatalf("StartWork failed %v", err)
 	}
@@ -102,7 +103,7 @@ func (ts *testScaffold) runFail(t *testing.T, jp JobParam, responseCode int, res
 	ts.errorMessage = errorMessage
 
 	apiw := &DefaultAPIWorker{}
-	err := apiw.StartWork(ts.config, jp, ts.client, ts.outputChannel)
+	err := apiw.StartWork(context.Background(), ts.config, jp, ts.client, ts.outputChannel)
 	if err == nil {
 		t.Fatalf("Test should have failed but it succedded")
 	}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// towerInfo records what the worker is talking to, it is fetched once
+// per run from the ping and config endpoints
+type towerInfo struct {
+	once           sync.Once
+	version        string
+	licenseType    string
+	instanceGroups []string
+	err            error
+}
+
+// detect fetches the version, license type and instance groups the
+// first time it is called
+func (t *towerInfo) detect(w *WorkUnit) error {
+	t.once.Do(func() {
+		ping, err := getAPIObject(w.context(), w.config, w.client, w.towerPath(pingSlug))
+		if err != nil {
+			t.err = err
+			log.Errorf("Error fetching ping: %v", err)
+			return
+		}
+		t.version, _ = ping["version"].(string)
+		if groups, ok := ping["instance_groups"].([]interface{}); ok {
+			for _, g := range groups {
+				if group, ok := g.(map[string]interface{}); ok {
+					if name, ok := group["name"].(string); ok {
+						t.instanceGroups = append(t.instanceGroups, name)
+					}
+				}
+			}
+		}
+
+		config, err := getAPIObject(w.context(), w.config, w.client, w.towerPath(defaultAPIPrefix+"config/"))
+		if err != nil {
+			log.Errorf("Error fetching config: %v", err)
+			return
+		}
+		if t.version == "" {
+			t.version, _ = config["version"].(string)
+		}
+		if license, ok := config["license_info"].(map[string]interface{}); ok {
+			t.licenseType, _ = license["license_type"].(string)
+		}
+		log.Infof("Ansible Tower version %s license %s", t.version, t.licenseType)
+	})
+	return t.err
+}
+
+// checkVersion fails a job that declares a min_version newer than the
+// version of Ansible Tower
+func (t *towerInfo) checkVersion(minVersion string) error {
+	if minVersion == "" {
+		return nil
+	}
+	if t.err != nil {
+		return fmt.Errorf("Unable to determine the Ansible Tower version required by min_version %s: %v", minVersion, t.err)
+	}
+	if t.version == "" {
+		return fmt.Errorf("Unable to determine the Ansible Tower version required by min_version %s", minVersion)
+	}
+	if compareVersions(t.version, minVersion) < 0 {
+		return fmt.Errorf("Ansible Tower version %s is older than the min_version %s required by the job", t.version, minVersion)
+	}
+	return nil
+}
+
+// summary is sent with the eof
+func (t *towerInfo) summary() map[string]interface{} {
+	if t.version == "" && t.err == nil {
+		return nil
+	}
+	result := map[string]interface{}{
+		"version":         t.version,
+		"license_type":    t.licenseType,
+		"instance_groups": t.instanceGroups,
+	}
+	if t.err != nil {
+		result["error"] = t.err.Error()
+	}
+	return result
+}
+
+// compareVersions compares dotted version numbers, anything after the
+// digits of a part like a release suffix is ignored
+func compareVersions(a string, b string) int {
+	pa, pb := versionParts(a), versionParts(b)
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var x, y int
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func versionParts(version string) []int {
+	var parts []int
+	for _, s := range strings.Split(version, ".") {
+		end := 0
+		for end < len(s) && s[end] >= '0' && s[end] <= '9' {
+			end++
+		}
+		n, err := strconv.Atoi(s[:end])
+		if err != nil {
+			break
+		}
+		parts = append(parts, n)
+		if end < len(s) {
+			break
+		}
+	}
+	return parts
+}
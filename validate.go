@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/mkanoor/catalog_worker/internal/filters"
+)
+
+// methods are the values accepted for the method of a job
+var methods = []string{"get", "post", "monitor", "monitor_batch", "catalog_sync", "ids_only", "reconcile", "ping"}
+
+// defaultHrefMethods can be used without a href_slug
+var defaultHrefMethods = []string{"monitor_batch", "catalog_sync", "ping"}
+
+// jobFields maps the keys of a job to the JobParam fields
+var jobFields = fieldsByTag(reflect.TypeOf(JobParam{}))
+
+// jobError holds the problems found in a job, the job is not run and a
+// single error response is sent for it
+type jobError struct {
+	index    int
+	hrefSlug string
+	errs     []error
+}
+
+func (e *jobError) Error() string {
+	messages := make([]string, len(e.errs))
+	for i, err := range e.errs {
+		messages[i] = err.Error()
+	}
+	return fmt.Sprintf("job %d: %s", e.index, strings.Join(messages, "; "))
+}
+
+// validateRequest returns an error for each invalid job in the request
+func validateRequest(req *RequestMessage) []error {
+	var errs []error
+	if len(req.Payload.Jobs) == 0 && len(req.invalidJobs) == 0 {
+		errs = append(errs, errors.New("payload has no jobs"))
+	}
+	if req.MessageID == "" {
+		errs = append(errs, errors.New("message_id is required"))
+	}
+	for i := range req.invalidJobs {
+		errs = append(errs, &req.invalidJobs[i])
+	}
+	return errs
+}
+
+// parseJobs decodes each job on its own so that a bad job doesn't stop
+// the others from running. It returns the valid jobs and the errors of
+// the invalid ones.
+func parseJobs(rawJobs []json.RawMessage) ([]JobParam, []jobError) {
+	var jobs []JobParam
+	var invalid []jobError
+	for i, raw := range rawJobs {
+		job, errs := parseJob(raw)
+		if len(errs) > 0 {
+			invalid = append(invalid, jobError{index: i + 1, hrefSlug: job.HrefSlug, errs: errs})
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, invalid
+}
+
+func parseJob(raw json.RawMessage) (JobParam, []error) {
+	var job JobParam
+	var values map[string]json.RawMessage
+	err := json.Unmarshal(raw, &values)
+	if err != nil || values == nil {
+		return job, []error{errors.New("job should be an object")}
+	}
+
+	var errs []error
+	for _, key := range sortedRawKeys(values) {
+		field, ok := jobFields[key]
+		if !ok {
+			errs = append(errs, unknownKeyError(key))
+			continue
+		}
+		value := reflect.New(field.Type)
+		err = decodeNumbers(values[key], value.Interface())
+		if err != nil {
+			errs = append(errs, typeError(key, field.Type, err))
+			continue
+		}
+		reflect.ValueOf(&job).Elem().FieldByIndex(field.Index).Set(value.Elem())
+	}
+	return job, append(errs, validateJob(job)...)
+}
+
+func validateJob(job JobParam) []error {
+	var errs []error
+	method := strings.ToLower(job.Method)
+	if method == "" {
+		errs = append(errs, errors.New("method is required"))
+	} else if !includes(method, methods) {
+		errs = append(errs, fmt.Errorf("method %s should be one of %s", job.Method, strings.Join(methods, ", ")))
+	}
+	if job.HrefSlug == "" && method != "" && !includes(method, defaultHrefMethods) {
+		errs = append(errs, errors.New("href_slug is required"))
+	}
+	if method == "monitor_batch" && len(job.JobIDs) == 0 {
+		errs = append(errs, errors.New("job_ids is required for monitor_batch"))
+	}
+	if job.RefreshIntervalSeconds < 0 {
+		errs = append(errs, errors.New("refresh_interval_seconds should not be negative"))
+	}
+	if _, err := filters.Lookup(job.FilterLanguage); err != nil {
+		errs = append(errs, err)
+	}
+	if _, ok := transforms[job.Transform]; job.Transform != "" && !ok {
+		errs = append(errs, fmt.Errorf("transform %s is unknown", job.Transform))
+	}
+	if job.SurveyJSONSchema && !isSurveySpec(job.HrefSlug) {
+		errs = append(errs, errors.New(surveySpecError))
+	}
+	if job.FetchAllPages && !job.MergePages && hasListSteps(job) {
+		errs = append(errs, errors.New(listStepsError))
+	}
+	return errs
+}
+
+const listStepsError = "sort_by, limit and dedupe work on a single page, use merge_pages with fetch_all_pages"
+
+// hasListSteps checks if the apply_filter pipeline has a list step, a
+// filter that doesn't parse is reported when the job is run
+func hasListSteps(job JobParam) bool {
+	if _, ok := job.ApplyFilter.([]interface{}); !ok {
+		return false
+	}
+	fltr := filters.Value{WrapKey: job.FilterResultKey, Language: job.FilterLanguage}
+	return fltr.Parse(job.ApplyFilter) == nil && fltr.HasListSteps()
+}
+
+const surveySpecError = "survey_json_schema can only be used with a survey_spec/ href_slug"
+
+// isSurveySpec checks that the path of a href_slug is a survey spec
+func isSurveySpec(hrefSlug string) bool {
+	path := strings.SplitN(hrefSlug, "?", 2)[0]
+	return strings.HasSuffix(path, "survey_spec/")
+}
+
+// unknownKeyError suggests the closest key, a key that only differs in
+// case or dashes or is a couple of typos away
+func unknownKeyError(key string) error {
+	normalized := strings.ToLower(strings.Replace(key, "-", "_", -1))
+	best, bestDistance := "", 3
+	for _, name := range sortedFieldNames() {
+		if name == normalized {
+			best = name
+			break
+		}
+		if d := editDistance(normalized, name); d < bestDistance {
+			best, bestDistance = name, d
+		}
+	}
+	if best == "" {
+		return fmt.Errorf("unknown key %s", key)
+	}
+	return fmt.Errorf("unknown key %s, did you mean %s?", key, best)
+}
+
+func typeError(key string, t reflect.Type, err error) error {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return fmt.Errorf("%s should be %s, got %s", key, typeName(t), typeErr.Value)
+	}
+	return fmt.Errorf("%s: %v", key, err)
+}
+
+func typeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.String:
+		return "a string"
+	case reflect.Int, reflect.Int64:
+		return "an integer"
+	case reflect.Slice:
+		return "a list of " + strings.TrimPrefix(strings.TrimPrefix(typeName(t.Elem()), "a "), "an ") + "s"
+	case reflect.Map:
+		if t.Elem().Kind() == reflect.Interface {
+			return "an object"
+		}
+		return "an object of " + strings.TrimPrefix(strings.TrimPrefix(typeName(t.Elem()), "a "), "an ") + "s"
+	}
+	return "a " + t.String()
+}
+
+func decodeNumbers(raw json.RawMessage, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+func fieldsByTag(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if name := strings.Split(field.Tag.Get("json"), ",")[0]; name != "" {
+			fields[name] = field
+		}
+	}
+	return fields
+}
+
+func sortedFieldNames() []string {
+	names := make([]string, 0, len(jobFields))
+	for name := range jobFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func sortedRawKeys(m map[string]json.RawMessage) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// editDistance is the Levenshtein distance between two strings
+func editDistance(a string, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, minInt(cur[j-1]+1, prev[j-1]+cost))
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
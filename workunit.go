@@ -3,26 +3,44 @@ package main
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"crypto/tls"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/mkanoor/catalog_worker/internal/artifacts"
+	"github.com/mkanoor/catalog_worker/internal/contenthash"
+	"github.com/mkanoor/catalog_worker/internal/credtypes"
 	"github.com/mkanoor/catalog_worker/internal/filters"
+	"github.com/mkanoor/catalog_worker/internal/httpcache"
+	"github.com/mkanoor/catalog_worker/internal/redact"
+	"github.com/mkanoor/catalog_worker/internal/survey"
 	log "github.com/sirupsen/logrus"
 )
 
+const unifiedJobsSlug = "/api/v2/unified_jobs/"
+const pingSlug = "/api/v2/ping/"
+
+// maxBatchSize is the largest page size Ansible Tower allows, so a
+// monitor_batch poll is split into chunks of this many job ids
+const maxBatchSize = 200
+
+var completedStatus = []string{"successful", "failed", "error", "canceled"}
+var allKnownStatus = []string{"new", "pending", "waiting", "running", "successful", "failed", "error", "canceled"}
+
 // WorkHandler is an interface to start a worker
 type WorkHandler interface {
-	StartWork(config *CatalogConfig, params JobParam, client *http.Client, channel chan ResponsePayload) error
+	StartWork(ctx context.Context, config *CatalogConfig, params JobParam, client *http.Client, channel chan ResponsePayload) error
 }
 
 // DefaultAPIWorker is struct to start a worker
@@ -30,28 +48,52 @@ type DefaultAPIWorker struct {
 }
 
 // StartWork can be started as a go routine to start a unit of work based on a given JobParam
-// The responses are sent to the Responder's channel so that it can rely it to the Receptor
-func (aw *DefaultAPIWorker) StartWork(config *CatalogConfig, params JobParam, client *http.Client, channel chan ResponsePayload) error {
-	w := &WorkUnit{outputChannel: channel}
+// The responses are sent to the Responder's channel so that it can rely it to the Receptor.
+// The work stops when the context is cancelled.
+func (aw *DefaultAPIWorker) StartWork(ctx context.Context, config *CatalogConfig, params JobParam, client *http.Client, channel chan ResponsePayload) error {
+	w := &WorkUnit{ctx: ctx, outputChannel: channel}
 	w.setConfig(config)
-	w.setJobParameters(params)
-	err := w.setURL()
+	err := w.setJobParameters(params)
 	if err != nil {
+		w.sendError(err.Error(), 0)
 		log.Error(err)
 		return err
 	}
 	w.setClient(client)
+	err = w.setURL()
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	if strings.ToLower(w.input.Method) == "ping" {
+		// ping reports on connectivity, nothing else is fetched before it
+		return w.dispatch()
+	}
+	if config.Tower != nil {
+		// detected once for the request, only min_version can fail the job
+		config.Tower.detect(w)
+		err = config.Tower.checkVersion(w.input.MinVersion)
+		if err != nil {
+			w.sendError(err.Error(), 0)
+			log.Error(err)
+			return err
+		}
+	}
 	return w.dispatch()
 }
 
 // WorkUnit is a data struct to store a single unit of work
 type WorkUnit struct {
+	ctx           context.Context
 	config        *CatalogConfig
 	hostURL       *url.URL
 	client        *http.Client
 	input         *JobParam
 	outputChannel chan ResponsePayload
 	filterValue   *filters.Value
+	mergedFilter  *filters.Value
+	artifacts     *artifacts.Rules
+	expandFilters map[string]*filters.Value
 	parsedURL     *url.URL
 	parsedValues  url.Values
 }
@@ -61,26 +103,84 @@ func (w *WorkUnit) setConfig(p *CatalogConfig) {
 	w.parseHost(p.URL)
 }
 
-func (w *WorkUnit) setJobParameters(data JobParam) {
-	if data.ApplyFilter != nil {
-		fltr := filters.Value{}
-		fltr.Parse(data.ApplyFilter)
-		w.filterValue = &fltr
-	}
+func (w *WorkUnit) setJobParameters(data JobParam) error {
 	if data.Params == nil {
 		data.Params = make(map[string]interface{})
 	}
+	if data.HrefSlug == "" && strings.ToLower(data.Method) == "monitor_batch" {
+		data.HrefSlug = unifiedJobsSlug
+	}
+	if data.HrefSlug == "" && strings.ToLower(data.Method) == "ping" {
+		data.HrefSlug = pingSlug
+	}
 	w.input = &data
+	if data.ApplyFilter != nil {
+		fltr := filters.Value{WrapKey: data.FilterResultKey, Language: data.FilterLanguage}
+		err := fltr.Parse(data.ApplyFilter)
+		if err != nil {
+			return err
+		}
+		w.filterValue = &fltr
+		if fltr.HasListSteps() && data.MergePages {
+			w.filterValue, w.mergedFilter = fltr.SplitAtListSteps()
+		} else if fltr.HasListSteps() && data.FetchAllPages {
+			return errors.New(listStepsError)
+		}
+	}
+	for key, element := range data.ExpandFilter {
+		fltr := filters.Value{WrapKey: data.FilterResultKey, Language: data.FilterLanguage}
+		err := fltr.Parse(element)
+		if err != nil {
+			return fmt.Errorf("expand_filter %s: %v", key, err)
+		}
+		// A related object is a single object, there are no results to replace
+		fltr.ReplaceResults = false
+		if w.expandFilters == nil {
+			w.expandFilters = make(map[string]*filters.Value)
+		}
+		w.expandFilters[key] = &fltr
+	}
+	if _, ok := transforms[data.Transform]; data.Transform != "" && !ok {
+		return errors.New("Invalid transform received " + data.Transform)
+	}
+	if data.SurveyJSONSchema && !isSurveySpec(data.HrefSlug) {
+		return errors.New(surveySpecError)
+	}
+	if data.ArtifactsSchema != nil {
+		schema, err := artifacts.ParseSchema(data.ArtifactsSchema)
+		if err != nil {
+			return err
+		}
+		w.artifacts = w.artifactRules().WithSchema(schema)
+	}
+	return nil
+}
+
+// subWork creates a WorkUnit for an additional request made on behalf of
+// this one, it shares the config, client and output channel
+func (w *WorkUnit) subWork(data JobParam) (*WorkUnit, error) {
+	sub := &WorkUnit{ctx: w.ctx, outputChannel: w.outputChannel, client: w.client}
+	sub.setConfig(w.config)
+	err := sub.setJobParameters(data)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	err = sub.setURL()
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return sub, nil
 }
 
 func (w *WorkUnit) setClient(c *http.Client) error {
 	if c == nil {
-		var tr *http.Transport
+		w.client = &http.Client{}
 		if w.config.SkipVerifyCertificate {
 			config := &tls.Config{InsecureSkipVerify: true}
-			tr = &http.Transport{TLSClientConfig: config}
+			w.client.Transport = &http.Transport{TLSClientConfig: config}
 		}
-		w.client = &http.Client{Transport: tr}
 	} else {
 		w.client = c
 	}
@@ -96,6 +196,16 @@ func (w *WorkUnit) dispatch() error {
 		err = w.post()
 	case "monitor":
 		err = w.monitor()
+	case "monitor_batch":
+		err = w.monitorBatch()
+	case "catalog_sync":
+		err = w.catalogSync()
+	case "ids_only":
+		err = w.idsOnly()
+	case "reconcile":
+		err = w.reconcile()
+	case "ping":
+		err = w.ping()
 	default:
 		err = errors.New("Invalid method received " + w.input.Method)
 		w.sendError(err.Error(), 0)
@@ -105,7 +215,7 @@ func (w *WorkUnit) dispatch() error {
 
 func (w *WorkUnit) setURL() error {
 	var err error
-	w.parsedURL, err = url.Parse(w.input.HrefSlug)
+	w.parsedURL, err = url.Parse(w.towerPath(w.input.HrefSlug))
 	if err != nil {
 		log.Error(err)
 		return err
@@ -155,33 +265,67 @@ func (w *WorkUnit) parseHost(host string) error {
 }
 
 func (w *WorkUnit) getPage() ([]byte, int, error) {
+	body, resp, err := w.fetchPage()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	err = w.validateHTTPResponse(resp, body)
+	if err != nil {
+		return nil, 0, err
+	}
+	return body, resp.StatusCode, nil
+}
+
+// fetchPage does the GET for the URL, unlike getPage it doesn't send
+// an error response when Ansible Tower returns a failure status
+func (w *WorkUnit) fetchPage() ([]byte, *http.Response, error) {
 	err := w.overrideQueryParams(w.input.Params)
 	if err != nil {
 		log.Error(err)
-		return nil, 0, err
+		return nil, nil, err
 	}
 
-	req, err := http.NewRequest("GET", w.parsedURL.String(), nil)
+	pageURL := w.parsedURL.String()
+	req, err := http.NewRequestWithContext(w.context(), "GET", pageURL, nil)
+	if err != nil {
+		log.Error(err)
+		return nil, nil, err
+	}
 	req.Header.Add("Authorization", "Bearer "+w.config.Token)
+	var cached *httpcache.Entry
+	if w.config.Cache != nil {
+		cached = w.config.Cache.Get(pageURL, w.config.Token)
+		if cached != nil {
+			cached.SetHeaders(req.Header)
+		}
+	}
 	resp, err := w.client.Do(req)
 	if err != nil {
 		log.Error(err)
-		return nil, 0, err
+		return nil, nil, err
 	}
 	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		log.Error(err)
-		return nil, 0, err
+		return nil, nil, err
 	}
 
-	log.Info("GET " + w.parsedURL.String() + " Status " + resp.Status)
-
-	err = w.validateHTTPResponse(resp, body)
-	if err != nil {
-		return nil, 0, err
+	log.Info("GET " + pageURL + " Status " + resp.Status)
+	if cached != nil && resp.StatusCode == http.StatusNotModified {
+		log.Info("Using cached response for " + pageURL)
+		resp.StatusCode = http.StatusOK
+		resp.Status = "200 OK"
+		return cached.Body, resp, nil
+	}
+	if w.config.Cache != nil && resp.StatusCode == http.StatusOK {
+		err = w.config.Cache.Put(pageURL, w.config.Token, resp.Header, body)
+		if err != nil {
+			log.Errorf("Error caching response for %s: %v", pageURL, err)
+		}
 	}
-	return []byte(body), resp.StatusCode, nil
+	return body, resp, nil
 }
 
 func (w *WorkUnit) validateHTTPResponse(resp *http.Response, body []byte) error {
@@ -201,7 +345,11 @@ func (w *WorkUnit) post() error {
 		return err
 	}
 
-	req, err := http.NewRequest("POST", w.parsedURL.String(), bytes.NewBuffer(b))
+	req, err := http.NewRequestWithContext(w.context(), "POST", w.parsedURL.String(), bytes.NewBuffer(b))
+	if err != nil {
+		log.Error(err)
+		return err
+	}
 	req.Header.Add("Authorization", "Bearer "+w.config.Token)
 	req.Header.Add("Content-Type", "application/json")
 	resp, err := w.client.Do(req)
@@ -229,7 +377,7 @@ func (w *WorkUnit) post() error {
 }
 
 func (w *WorkUnit) sendResponse(body []byte, status int) (map[string]interface{}, error) {
-	jsonBody, err := w.createJSON(body)
+	jsonBody, err := w.createPage(body)
 	if err != nil {
 		log.Error(err)
 		return nil, err
@@ -243,14 +391,27 @@ func (w *WorkUnit) sendResponse(body []byte, status int) (map[string]interface{}
 }
 
 func (w *WorkUnit) get() error {
+	if w.input.MergePages {
+		return w.getMerged()
+	}
+	return w.eachPage(w.writePage)
+}
 
+// eachPage fetches the page for the URL and when fetch_all_pages is set
+// all the following pages, calling fn with the processed body of each page
+func (w *WorkUnit) eachPage(fn func(jsonBody map[string]interface{}, status int) error) error {
 	body, httpStatus, err := w.getPage()
 	if err != nil {
 		log.Error("Get failed")
 		return err
 	}
 
-	jsonBody, err := w.sendResponse(body, httpStatus)
+	jsonBody, err := w.createPage(body)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	err = fn(jsonBody, httpStatus)
 	if err != nil {
 		log.Error(err)
 		return err
@@ -259,13 +420,22 @@ func (w *WorkUnit) get() error {
 	if w.input.FetchAllPages {
 		nextPage := jsonBody["next"]
 		for page := 2; reflect.TypeOf(nextPage) == reflect.TypeOf("string"); page++ {
+			if err := w.context().Err(); err != nil {
+				log.Error(err)
+				return err
+			}
 			w.input.Params["page"] = strconv.Itoa(page)
 			body, httpStatus, err := w.getPage()
 			if err != nil {
 				log.Error("Get failed")
 				return err
 			}
-			jsonBody, err := w.sendResponse(body, httpStatus)
+			jsonBody, err := w.createPage(body)
+			if err != nil {
+				log.Error(err)
+				return err
+			}
+			err = fn(jsonBody, httpStatus)
 			if err != nil {
 				log.Error(err)
 				return err
@@ -276,10 +446,92 @@ func (w *WorkUnit) get() error {
 	return nil
 }
 
+// getMerged collects the results of all the pages and sends them as a
+// single response. If the collected results grow past the configured
+// memory cap they are sent in chunks instead.
+func (w *WorkUnit) getMerged() error {
+	var merged map[string]interface{}
+	results := []interface{}{}
+	var unchanged []interface{}
+	var status, size, pages, chunk, redactions int
+
+	flush := func(last bool) error {
+		chunk++
+		body := make(map[string]interface{}, len(merged))
+		for k, v := range merged {
+			body[k] = v
+		}
+		body["results"] = results
+		body["next"] = nil
+		body["previous"] = nil
+		body["merged_pages"] = pages
+		delete(body, "redactions")
+		if redactions > 0 {
+			body["redactions"] = redactions
+		}
+		if _, ok := body["unchanged_ids"]; ok {
+			body["unchanged_ids"] = append([]interface{}{}, unchanged...)
+		}
+		if !last || chunk > 1 {
+			body["chunk"] = chunk
+			body["chunk_count"] = len(results)
+			body["last_chunk"] = last
+		}
+		if w.mergedFilter != nil {
+			var err error
+			body, err = w.mergedFilter.Apply(body)
+			if err != nil {
+				w.sendError(err.Error(), 0)
+				return err
+			}
+		}
+		return w.writePage(body, status)
+	}
+
+	err := w.eachPage(func(jsonBody map[string]interface{}, httpStatus int) error {
+		pageResults, ok := jsonBody["results"].([]interface{})
+		if !ok {
+			err := errors.New("merge_pages requires a list response with results")
+			w.sendError(err.Error(), 0)
+			return err
+		}
+		b, err := json.Marshal(pageResults)
+		if err != nil {
+			return err
+		}
+		// The list steps need all the results, they are not sent in chunks
+		if merged != nil && w.mergedFilter == nil && w.config.MergePagesMaxBytes > 0 && int64(size+len(b)) > w.config.MergePagesMaxBytes {
+			log.Infof("Merged pages exceed %d bytes, sending chunk", w.config.MergePagesMaxBytes)
+			err = flush(false)
+			if err != nil {
+				return err
+			}
+			results, size, pages, redactions, unchanged = []interface{}{}, 0, 0, 0, nil
+		}
+		if merged == nil {
+			merged = jsonBody
+			status = httpStatus
+		}
+		results = append(results, pageResults...)
+		size += len(b)
+		pages++
+		if n, ok := jsonBody["redactions"].(int); ok {
+			redactions += n
+		}
+		if ids, ok := jsonBody["unchanged_ids"].([]interface{}); ok {
+			unchanged = append(unchanged, ids...)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	return flush(true)
+}
+
 func (w *WorkUnit) monitor() error {
 
-	var completedStatus = []string{"successful", "failed", "error", "canceled"}
-	var allKnownStatus = []string{"new", "pending", "waiting", "running", "successful", "failed", "error", "canceled"}
 	var body []byte
 	var err error
 	var httpStatus int
@@ -317,8 +569,11 @@ func (w *WorkUnit) monitor() error {
 
 		if includes(status, completedStatus) {
 			break
-		} else {
-			time.Sleep(time.Duration(w.input.RefreshIntervalSeconds) * time.Second)
+		}
+		err = w.sleep(time.Duration(w.input.RefreshIntervalSeconds) * time.Second)
+		if err != nil {
+			log.Error(err)
+			return err
 		}
 	}
 
@@ -331,6 +586,166 @@ func (w *WorkUnit) monitor() error {
 	return nil
 }
 
+// monitorBatch polls a set of jobs with a single id__in query per poll
+// instead of one request per job. A response is sent for each job as it
+// reaches a terminal state and the work finishes when all jobs are done.
+func (w *WorkUnit) monitorBatch() error {
+	if len(w.input.JobIDs) == 0 {
+		err := errors.New("monitor_batch requires a list of job_ids")
+		w.sendError(err.Error(), 0)
+		log.Error(err)
+		return err
+	}
+	if w.input.RefreshIntervalSeconds == 0 {
+		w.input.RefreshIntervalSeconds = 10
+	}
+
+	pending := make(map[int64]bool)
+	for _, id := range w.input.JobIDs {
+		pending[id] = true
+	}
+
+	for len(pending) > 0 {
+		ids := sortedIDs(pending)
+		for start := 0; start < len(ids); start += maxBatchSize {
+			end := start + maxBatchSize
+			if end > len(ids) {
+				end = len(ids)
+			}
+			err := w.pollBatch(ids[start:end], pending)
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(pending) > 0 {
+			err := w.sleep(time.Duration(w.input.RefreshIntervalSeconds) * time.Second)
+			if err != nil {
+				log.Error(err)
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// pollBatch fetches a single chunk of jobs, sends a response for every job
+// that has completed and removes it from the pending set.
+func (w *WorkUnit) pollBatch(ids []int64, pending map[int64]bool) error {
+	strIDs := make([]string, len(ids))
+	for i, id := range ids {
+		strIDs[i] = strconv.FormatInt(id, 10)
+	}
+	w.input.Params["id__in"] = strings.Join(strIDs, ",")
+	w.input.Params["page_size"] = strconv.Itoa(len(ids))
+
+	body, httpStatus, err := w.getPage()
+	if err != nil {
+		log.Error("Get failed")
+		return err
+	}
+
+	jsonBody, err := decodeJSON(body)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+
+	results, ok := jsonBody["results"].([]interface{})
+	if !ok {
+		err = errors.New("Object does not contain a results list")
+		w.sendError(err.Error(), 0)
+		log.Error(err)
+		return err
+	}
+
+	seen := make(map[int64]bool)
+	for _, r := range results {
+		job, ok := r.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		n, ok := job["id"].(json.Number)
+		if !ok {
+			continue
+		}
+		id, err := n.Int64()
+		if err != nil || !pending[id] {
+			continue
+		}
+		seen[id] = true
+
+		status, _ := job["status"].(string)
+		if !includes(status, allKnownStatus) {
+			err = fmt.Errorf("Job %d Status: %s is not one of the known status", id, status)
+			w.sendError(err.Error(), 0)
+			log.Error(err)
+			delete(pending, id)
+			continue
+		}
+		if !includes(status, completedStatus) {
+			continue
+		}
+
+		href, ok := job["url"].(string)
+		if ok {
+			href = w.canonicalPath(href)
+		} else {
+			href = w.input.HrefSlug
+		}
+		job, err = w.processJSON(job)
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+		err = w.writeObject(href, job, httpStatus)
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+		delete(pending, id)
+	}
+
+	for _, id := range ids {
+		if !seen[id] {
+			err = fmt.Errorf("Job %d was not found", id)
+			w.sendError(err.Error(), 0)
+			log.Error(err)
+			delete(pending, id)
+		}
+	}
+	return nil
+}
+
+// context returns the context of the work, a WorkUnit created without one
+// is never cancelled
+func (w *WorkUnit) context() context.Context {
+	if w.ctx == nil {
+		return context.Background()
+	}
+	return w.ctx
+}
+
+// sleep waits between polls, it returns early with the context error when
+// the work is cancelled
+func (w *WorkUnit) sleep(d time.Duration) error {
+	select {
+	case <-time.After(d):
+		return nil
+	case <-w.context().Done():
+		return w.context().Err()
+	}
+}
+
+func sortedIDs(ids map[int64]bool) []int64 {
+	result := make([]int64, 0, len(ids))
+	for id := range ids {
+		result = append(result, id)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result
+}
+
 func includes(s string, values []string) bool {
 	for _, v := range values {
 		if v == s {
@@ -340,39 +755,227 @@ func includes(s string, values []string) bool {
 	return false
 }
 
-func (w *WorkUnit) createJSON(body []byte) (map[string]interface{}, error) {
+func decodeJSON(body []byte) (map[string]interface{}, error) {
 	var jsonBody map[string]interface{}
 	decoder := json.NewDecoder(bytes.NewReader(body))
 	decoder.UseNumber()
 	err := decoder.Decode(&jsonBody)
+	if err != nil {
+		return nil, err
+	}
+	return jsonBody, nil
+}
+
+func (w *WorkUnit) createJSON(body []byte) (map[string]interface{}, error) {
+	jsonBody, err := decodeJSON(body)
 	if err != nil {
 		log.Error(err)
 		return nil, err
 	}
+	return w.processJSON(jsonBody)
+}
+
+// createPage builds the JSON body of a page and runs the transform
+// requested for the job on it
+func (w *WorkUnit) createPage(body []byte) (map[string]interface{}, error) {
+	jsonBody, err := w.createJSON(body)
+	if err != nil {
+		return nil, err
+	}
+	if w.input.Transform != "" {
+		jsonBody, err = applyTransform(transforms[w.input.Transform], jsonBody)
+		if err != nil {
+			w.sendError(err.Error(), 0)
+			log.Error(err)
+			return nil, err
+		}
+	}
+	if w.input.ContentHash || len(w.input.KnownHashes) > 0 {
+		jsonBody, err = w.hashObjects(jsonBody)
+		if err != nil {
+			w.sendError(err.Error(), 0)
+			log.Error(err)
+			return nil, err
+		}
+	}
+	return jsonBody, nil
+}
+
+// hashObjects adds the content_hash to each object in the results of a
+// list response or to the body of a single object response. Objects whose
+// hash matches the one in known_hashes for their id are left out and only
+// their ids are reported in unchanged_ids.
+func (w *WorkUnit) hashObjects(jsonBody map[string]interface{}) (map[string]interface{}, error) {
+	unchanged := []interface{}{}
+	isUnchanged := func(object map[string]interface{}) bool {
+		id, ok := object["id"]
+		if !ok {
+			return false
+		}
+		known, ok := w.input.KnownHashes[fmt.Sprintf("%v", id)]
+		return ok && known == object[contenthash.Key]
+	}
+
+	// The redactions of a single object response are not part of its content
+	results, isList := jsonBody["results"].([]interface{})
+	redactions, hasRedactions := jsonBody["redactions"]
+	if !isList {
+		delete(jsonBody, "redactions")
+	}
+	var err error
+	filters.EachObject(jsonBody, func(object map[string]interface{}) {
+		if err != nil {
+			return
+		}
+		var sum string
+		sum, err = contenthash.Sum(object)
+		if err != nil {
+			return
+		}
+		object[contenthash.Key] = sum
+		if isUnchanged(object) {
+			unchanged = append(unchanged, object["id"])
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if isList {
+		changed := make([]interface{}, 0, len(results))
+		for _, item := range results {
+			if object, ok := item.(map[string]interface{}); ok && isUnchanged(object) {
+				continue
+			}
+			changed = append(changed, item)
+		}
+		jsonBody["results"] = changed
+	} else if isUnchanged(jsonBody) {
+		jsonBody = map[string]interface{}{"id": jsonBody["id"], contenthash.Key: jsonBody[contenthash.Key]}
+	} else if hasRedactions {
+		jsonBody["redactions"] = redactions
+	}
+	if len(w.input.KnownHashes) > 0 {
+		jsonBody["unchanged_ids"] = unchanged
+	}
+	return jsonBody, nil
+}
+
+// transforms rewrite each object of a response into another shape
+var transforms = map[string]func(map[string]interface{}) (map[string]interface{}, error){
+	"credential_type_schema": credtypes.Normalize,
+}
+
+// annotationKeys are added to an object by the worker, they are kept
+// when the object is transformed
+var annotationKeys = []string{"redactions", "artifacts_report", "artifacts_errors"}
+
+// applyTransform runs the transform on each object in the results of a
+// list response or on the body of a single object response
+func applyTransform(fn func(map[string]interface{}) (map[string]interface{}, error), jsonBody map[string]interface{}) (map[string]interface{}, error) {
+	var err error
+	filters.EachObject(jsonBody, func(object map[string]interface{}) {
+		if err != nil {
+			return
+		}
+		var transformed map[string]interface{}
+		transformed, err = transformObject(fn, object)
+		if err != nil {
+			return
+		}
+		// The object is replaced in place, it is part of the results
+		for key := range object {
+			delete(object, key)
+		}
+		for key, value := range transformed {
+			object[key] = value
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return jsonBody, nil
+}
+
+func transformObject(fn func(map[string]interface{}) (map[string]interface{}, error), object map[string]interface{}) (map[string]interface{}, error) {
+	transformed, err := fn(object)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range annotationKeys {
+		if value, ok := object[key]; ok {
+			transformed[key] = value
+		}
+	}
+	return transformed, nil
+}
+
+// processJSON applies the filter and artifact rules to a decoded object
+func (w *WorkUnit) processJSON(jsonBody map[string]interface{}) (map[string]interface{}, error) {
+	var err error
+	if w.config.API != nil && w.config.API.discover(w.context(), w.config, w.client) != defaultAPIPrefix {
+		w.canonicalLinks(jsonBody)
+	}
+	// expand needs the related links that a filter may leave out
+	if len(w.input.Expand) > 0 {
+		w.expand(jsonBody)
+	}
 
 	if w.filterValue != nil {
 		jsonBody, err = w.filterValue.Apply(jsonBody)
 		if err != nil {
+			w.sendError(err.Error(), 0)
 			log.Error(err)
 			return nil, err
 		}
 	}
 
-	v, ok := jsonBody["artifacts"]
-	if ok {
-		s, err := artifacts.Sanctify(v.(map[string]interface{}))
+	if w.input.SurveyJSONSchema {
+		jsonBody, err = survey.ToJSONSchema(jsonBody)
 		if err != nil {
+			w.sendError(err.Error(), 0)
 			log.Error(err)
 			return nil, err
 		}
-		jsonBody["artifacts"] = s
+	}
+
+	err = w.artifactRules().SanctifyAll(jsonBody)
+	if err != nil {
+		w.sendError(err.Error(), 0)
+		log.Error(err)
+		return nil, err
+	}
+
+	if n := w.redactor().Apply(jsonBody); n > 0 {
+		jsonBody["redactions"] = n
 	}
 	return jsonBody, nil
 }
 
+func (w *WorkUnit) artifactRules() *artifacts.Rules {
+	if w.artifacts != nil {
+		return w.artifacts
+	}
+	if w.config.Artifacts == nil {
+		return artifacts.DefaultRules()
+	}
+	return w.config.Artifacts
+}
+
+func (w *WorkUnit) redactor() *redact.Redactor {
+	if w.config.Redactor == nil {
+		return redact.Default()
+	}
+	return w.config.Redactor
+}
+
 func (w *WorkUnit) writePage(jsonBody map[string]interface{}, status int) error {
+	return w.writeObject(w.input.HrefSlug, jsonBody, status)
+}
+
+func (w *WorkUnit) writeObject(hrefSlug string, jsonBody map[string]interface{}, status int) error {
 	var bytes []byte
-	rd := ResponseData{HrefSlug: w.input.HrefSlug, Status: status}
+	rd := ResponseData{HrefSlug: hrefSlug, Status: status}
 	bytes, err := json.Marshal(jsonBody)
 	if err != nil {
 		log.Error(err)
@@ -389,7 +992,7 @@ func (w *WorkUnit) writePage(jsonBody map[string]interface{}, status int) error
 		bytes = []byte(base64.StdEncoding.EncodeToString(bytes))
 	}
 	rd.Body = string(bytes)
-	log.Debugf("Sending response for %s", w.input.HrefSlug)
+	log.Debugf("Sending response for %s", hrefSlug)
 	w.outputChannel <- ResponsePayload{messageType: "data", code: 0, data: rd}
 	return nil
 }
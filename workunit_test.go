@@ -1,7 +1,19 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/mkanoor/catalog_worker/internal/artifacts"
+	"github.com/mkanoor/catalog_worker/internal/contenthash"
+	"github.com/mkanoor/catalog_worker/internal/httpcache"
 )
 
 func TestGet(t *testing.T) {
@@ -30,6 +42,122 @@ func TestGet(t *testing.T) {
 	ts.runSuccess(t, jp, 200, responseBody, responses)
 }
 
+func TestGetMergePages(t *testing.T) {
+	responseBody := []string{`{"count": 4, "previous": null, "next": "/page/2", "results": [ {"name": "jt1", "id": 1, "url": "url1"},{"name": "jt2", "id": 2, "url":"url2"}]}`,
+		`{"count": 4, "previous": "/page/1", "next": null, "results": [ {"name": "jt3", "id": 3, "url": "url3"},{"name": "jt4", "id": 4, "url": "url4"}]}`}
+
+	responses := []map[string]interface{}{
+		{
+			"count":        4,
+			"next":         nil,
+			"results":      []interface{}{},
+			"merged_pages": 2,
+		},
+	}
+	jp := JobParam{
+		Method:        "get",
+		HrefSlug:      "/api/v2/job_templates",
+		FetchAllPages: true,
+		MergePages:    true,
+		ApplyFilter:   "results[].{id:id, url:url}",
+	}
+
+	ts := &testScaffold{}
+	ts.runSuccess(t, jp, 200, responseBody, responses)
+}
+
+func TestGetMergePagesChunked(t *testing.T) {
+	responseBody := []string{`{"count": 4, "previous": null, "next": "/page/2", "results": [ {"name": "jt1", "id": 1, "url": "url1"},{"name": "jt2", "id": 2, "url":"url2"}]}`,
+		`{"count": 4, "previous": "/page/1", "next": null, "results": [ {"name": "jt3", "id": 3, "url": "url3"},{"name": "jt4", "id": 4, "url": "url4"}]}`}
+
+	jp := JobParam{
+		Method:        "get",
+		HrefSlug:      "/api/v2/job_templates",
+		FetchAllPages: true,
+		MergePages:    true,
+	}
+
+	config := &CatalogConfig{URL: "https://192.1.1.1", Token: "123", MergePagesMaxBytes: 10}
+	channel := make(chan ResponsePayload, 2)
+	apiw := &DefaultAPIWorker{}
+	err := apiw.StartWork(context.Background(), config, jp, fakeClient(t, responseBody, 200), channel)
+	if err != nil {
+		t.Fatalf("StartWork failed %v", err)
+	}
+	for chunk := 1; chunk <= 2; chunk++ {
+		payload := <-channel
+		var body map[string]interface{}
+		err = json.Unmarshal([]byte(payload.data.Body), &body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body["count"] != float64(4) || body["chunk_count"] != float64(2) || body["chunk"] != float64(chunk) || body["last_chunk"] != (chunk == 2) {
+			t.Errorf("Chunk %d didn't match %v", chunk, body)
+		}
+	}
+}
+
+func TestGetMergePagesEmpty(t *testing.T) {
+	responseBody := []string{`{"count": 0, "previous": null, "next": null, "results": []}`}
+	jp := JobParam{
+		Method:        "get",
+		HrefSlug:      "/api/v2/job_templates",
+		FetchAllPages: true,
+		MergePages:    true,
+	}
+
+	channel := make(chan ResponsePayload, 1)
+	apiw := &DefaultAPIWorker{}
+	err := apiw.StartWork(context.Background(), &CatalogConfig{URL: "https://192.1.1.1", Token: "123"}, jp, fakeClient(t, responseBody, 200), channel)
+	if err != nil {
+		t.Fatalf("StartWork failed %v", err)
+	}
+	payload := <-channel
+	if !strings.Contains(payload.data.Body, `"results":[]`) {
+		t.Errorf("Empty results should be a list %s", payload.data.Body)
+	}
+}
+
+func TestGetMergePagesListSteps(t *testing.T) {
+	responseBody := []string{`{"count": 4, "previous": null, "next": "/page/2", "results": [ {"name": "jt1", "id": 1},{"name": "jt2", "id": 2}]}`,
+		`{"count": 4, "previous": "/page/1", "next": null, "results": [ {"name": "jt3", "id": 3},{"name": "jt4", "id": 4}]}`}
+
+	jp := JobParam{
+		Method:        "get",
+		HrefSlug:      "/api/v2/job_templates",
+		FetchAllPages: true,
+		MergePages:    true,
+		ApplyFilter: []interface{}{
+			map[string]interface{}{"filter": "results[].{id:id}"},
+			map[string]interface{}{"sort_by": "-id"},
+			map[string]interface{}{"limit": json.Number("1")},
+		},
+	}
+
+	config := &CatalogConfig{URL: "https://192.1.1.1", Token: "123", MergePagesMaxBytes: 10}
+	channel := make(chan ResponsePayload, 1)
+	apiw := &DefaultAPIWorker{}
+	err := apiw.StartWork(context.Background(), config, jp, fakeClient(t, responseBody, 200), channel)
+	if err != nil {
+		t.Fatalf("StartWork failed %v", err)
+	}
+	payload := <-channel
+	if !strings.Contains(payload.data.Body, `"results":[{"id":4}]`) || strings.Contains(payload.data.Body, `"chunk"`) {
+		t.Errorf("Merged results didn't match %s", payload.data.Body)
+	}
+}
+
+func TestGetListStepsAllPages(t *testing.T) {
+	jp := JobParam{
+		Method:        "get",
+		HrefSlug:      "/api/v2/job_templates",
+		FetchAllPages: true,
+		ApplyFilter:   []interface{}{map[string]interface{}{"sort_by": "-id"}},
+	}
+	ts := &testScaffold{}
+	ts.runFail(t, jp, 200, []string{"Should not be fetched"}, listStepsError)
+}
+
 func TestMonitor(t *testing.T) {
 	responseBody := []string{`{"name": "job15", "id": 15, "url": "url15","status":"waiting"}`,
 		`{"name": "job15", "id": 15, "url": "url15", "status":"successful"}`}
@@ -50,6 +178,23 @@ func TestMonitor(t *testing.T) {
 	ts.runSuccess(t, jp, 200, responseBody, responses)
 }
 
+func TestMonitorCancelled(t *testing.T) {
+	responseBody := []string{`{"id": 7, "status": "running"}`, `{"id": 7, "status": "running"}`}
+	jp := JobParam{Method: "monitor", HrefSlug: "/api/v2/jobs/7/", RefreshIntervalSeconds: 60}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	channel := make(chan ResponsePayload, 1)
+	apiw := &DefaultAPIWorker{}
+	start := time.Now()
+	err := apiw.StartWork(ctx, &CatalogConfig{URL: "https://192.1.1.1", Token: "123"}, jp, fakeClient(t, responseBody, 200), channel)
+	if err != context.Canceled {
+		t.Fatalf("Monitor should have been cancelled %v", err)
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Errorf("Monitor did not stop when cancelled")
+	}
+}
+
 func TestMonitorMissing(t *testing.T) {
 	responseBody := []string{"Job Missing"}
 	jp := JobParam{
@@ -83,6 +228,695 @@ func TestMonitorStatusInvalid(t *testing.T) {
 	ts.runFail(t, jp, 200, responseBody, "Status: Charkie is not one of the known status")
 }
 
+func TestMonitorBatch(t *testing.T) {
+	responseBody := []string{`{"count": 2, "results": [{"id": 15, "url": "/api/v2/jobs/15/", "status": "successful"}, {"id": 16, "url": "/api/v2/jobs/16/", "status": "running"}]}`,
+		`{"count": 1, "results": [{"id": 16, "url": "/api/v2/jobs/16/", "status": "failed"}]}`}
+
+	responses := []map[string]interface{}{
+		{
+			"id":     15,
+			"status": "successful",
+		},
+		{
+			"id":     16,
+			"status": "failed",
+		},
+	}
+	jp := JobParam{
+		Method:                 "monitor_batch",
+		JobIDs:                 []int64{15, 16},
+		RefreshIntervalSeconds: 1,
+		AcceptEncoding:         "gzip",
+	}
+	ts := &testScaffold{}
+	ts.runSuccess(t, jp, 200, responseBody, responses)
+}
+
+func TestMonitorBatchMissingIDs(t *testing.T) {
+	responseBody := []string{"Fail"}
+	jp := JobParam{
+		Method: "monitor_batch",
+	}
+	ts := &testScaffold{}
+	ts.runFail(t, jp, 200, responseBody, "monitor_batch requires a list of job_ids")
+}
+
+func TestCatalogSync(t *testing.T) {
+	responseBody := []string{
+		`{"count": 2, "previous": null, "next": null, "results": [{"id": 1, "name": "jt1", "survey_enabled": true, "extra": "x"}, {"id": 2, "name": "jt2", "survey_enabled": false}]}`,
+		`{"count": 0, "previous": null, "next": null, "results": []}`,
+		`{"count": 1, "previous": null, "next": null, "results": [{"id": 5, "name": "inv1", "kind": ""}]}`,
+		`{"count": 1, "previous": null, "next": null, "results": [{"id": 7, "name": "cred1", "credential_type": 1}]}`,
+		`{"count": 1, "previous": null, "next": null, "results": [{"id": 1, "name": "Machine", "kind": "ssh"}]}`,
+		`{"name": "", "description": "", "spec": [{"variable": "username", "type": "text"}]}`,
+	}
+	responses := []map[string]interface{}{
+		{"object_type": "job_templates", "results": []interface{}{}},
+		{"object_type": "workflow_job_templates", "results": []interface{}{}},
+		{"object_type": "inventories", "results": []interface{}{}},
+		{"object_type": "credentials", "results": []interface{}{}},
+		{"object_type": "credential_types", "results": []interface{}{}},
+		{"object_type": "survey_spec", "spec": []interface{}{}},
+		{"object_type": "manifest", "manifest": map[string]interface{}{}, "object_types": []interface{}{}},
+	}
+	jp := JobParam{
+		Method:         "catalog_sync",
+		AcceptEncoding: "gzip",
+	}
+	ts := &testScaffold{}
+	ts.runSuccess(t, jp, 200, responseBody, responses)
+}
+
+func TestGetSurveyJSONSchema(t *testing.T) {
+	responseBody := []string{`{"name": "Deploy", "description": "", "spec": [{"variable": "username", "question_name": "User", "type": "text", "required": true, "min": 0, "max": 32, "default": ""}]}`}
+	responses := []map[string]interface{}{
+		{
+			"$schema":    "http://json-schema.org/draft-07/schema#",
+			"type":       "object",
+			"properties": map[string]interface{}{},
+			"required":   []interface{}{},
+		},
+	}
+	jp := JobParam{
+		Method:           "get",
+		HrefSlug:         "/api/v2/job_templates/5/survey_spec/",
+		SurveyJSONSchema: true,
+	}
+	ts := &testScaffold{}
+	ts.runSuccess(t, jp, 200, responseBody, responses)
+}
+
+func TestGetSurveyJSONSchemaInvalid(t *testing.T) {
+	responseBody := []string{`{"name": "job15", "id": 15}`}
+	jp := JobParam{
+		Method:           "get",
+		HrefSlug:         "/api/v2/job_templates/15/survey_spec/",
+		SurveyJSONSchema: true,
+	}
+	ts := &testScaffold{}
+	ts.runFail(t, jp, 200, responseBody, "Survey spec does not contain a spec list")
+}
+
+func TestGetSurveyJSONSchemaNotSurveySpec(t *testing.T) {
+	responseBody := []string{`{"name": "job15", "id": 15}`}
+	jp := JobParam{
+		Method:           "get",
+		HrefSlug:         "/api/v2/jobs/15/",
+		SurveyJSONSchema: true,
+	}
+	ts := &testScaffold{}
+	ts.runFail(t, jp, 200, responseBody, surveySpecError)
+}
+
+func TestGetCredentialTypeTransform(t *testing.T) {
+	responseBody := []string{`{"count": 1, "previous": null, "next": null, "results": [{"id": 1, "name": "Machine", "kind": "ssh",
+		"inputs": {"fields": [{"id": "username", "label": "Username", "type": "string"}, {"id": "password", "label": "Password", "type": "string", "secret": true}], "required": ["username"]}}]}`}
+	responses := []map[string]interface{}{
+		{
+			"count":   1,
+			"results": []interface{}{},
+		},
+	}
+	jp := JobParam{
+		Method:    "get",
+		HrefSlug:  "/api/v2/credential_types/",
+		Transform: "credential_type_schema",
+	}
+	ts := &testScaffold{}
+	ts.runSuccess(t, jp, 200, responseBody, responses)
+}
+
+func TestGetUnknownTransform(t *testing.T) {
+	responseBody := []string{"Should not be fetched"}
+	jp := JobParam{
+		Method:    "get",
+		HrefSlug:  "/api/v2/credential_types/",
+		Transform: "xml",
+	}
+	ts := &testScaffold{}
+	ts.runFail(t, jp, 200, responseBody, "Invalid transform received xml")
+}
+
+func TestGetTransformNotCredentialType(t *testing.T) {
+	responseBody := []string{`{"name": "job15", "id": 15}`}
+	jp := JobParam{
+		Method:    "get",
+		HrefSlug:  "/api/v2/jobs/15",
+		Transform: "credential_type_schema",
+	}
+	ts := &testScaffold{}
+	ts.runFail(t, jp, 200, responseBody, "does not contain inputs")
+}
+
+func TestApplyTransformSingleObject(t *testing.T) {
+	jsonBody := map[string]interface{}{"id": 1, "name": "Machine", "kind": "ssh",
+		"inputs":           map[string]interface{}{"fields": []interface{}{map[string]interface{}{"id": "username", "label": "Username", "type": "string"}}},
+		"redactions":       2,
+		"artifacts_report": map[string]interface{}{"dropped": []interface{}{"secret"}},
+	}
+	result, err := applyTransform(transforms["credential_type_schema"], jsonBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result["redactions"] != 2 || !reflect.DeepEqual(result["artifacts_report"], jsonBody["artifacts_report"]) {
+		t.Errorf("Annotations should be kept %v", result)
+	}
+	if _, ok := result["inputs"]; ok {
+		t.Errorf("Object should be transformed %v", result)
+	}
+}
+
+func TestGetExpand(t *testing.T) {
+	responseBody := []string{`{"count": 3, "previous": null, "next": null, "results": [
+		{"id": 1, "name": "jt1", "related": {"inventory": "/api/v2/inventories/5/"}},
+		{"id": 2, "name": "jt2", "related": {"inventory": "/api/v2/inventories/5/"}},
+		{"id": 3, "name": "jt3", "related": {}}]}`,
+		`{"id": 5, "name": "inv1", "kind": "", "variables": "---"}`}
+	responses := []map[string]interface{}{
+		{
+			"count":   3,
+			"results": []interface{}{},
+		},
+	}
+	jp := JobParam{
+		Method:       "get",
+		HrefSlug:     "/api/v2/job_templates/",
+		Expand:       []string{"inventory"},
+		ExpandFilter: map[string]interface{}{"inventory": map[string]interface{}{"id": "id", "name": "name"}},
+	}
+	ts := &testScaffold{}
+	ts.runSuccess(t, jp, 200, responseBody, responses)
+}
+
+func TestSetClientDefault(t *testing.T) {
+	w := &WorkUnit{}
+	w.setConfig(&CatalogConfig{URL: "https://192.1.1.1"})
+	w.setClient(nil)
+	if w.client.Transport != nil {
+		t.Errorf("Default client should use the default transport %#v", w.client.Transport)
+	}
+
+	w.setConfig(&CatalogConfig{URL: "https://192.1.1.1", SkipVerifyCertificate: true})
+	w.setClient(nil)
+	tr, ok := w.client.Transport.(*http.Transport)
+	if !ok || !tr.TLSClientConfig.InsecureSkipVerify {
+		t.Errorf("Client should skip certificate verification %#v", w.client.Transport)
+	}
+}
+
+func TestExpandRelated(t *testing.T) {
+	responseBody := []string{`{"id": 5, "name": "inv1", "kind": ""}`, "Not Found"}
+	jp := JobParam{
+		Method:       "get",
+		HrefSlug:     "/api/v2/job_templates/7/",
+		Expand:       []string{"inventory", "project", "credentials"},
+		ExpandFilter: map[string]interface{}{"inventory": "name"},
+	}
+	ts := &testScaffold{}
+	ts.base(t, jp, 200, responseBody)
+	w := &ts.work
+	w.setConfig(ts.config)
+	w.setClient(ts.client)
+	err := w.setJobParameters(jp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jsonBody := map[string]interface{}{"id": 7, "related": map[string]interface{}{
+		"inventory": "/api/v2/inventories/5/", "project": "/api/v2/projects/9/"}}
+	w.expand(jsonBody)
+	expanded := jsonBody["expanded"].(map[string]interface{})
+	inventory := expanded["inventory"].(map[string]interface{})
+	if inventory["result"] != "inv1" {
+		t.Errorf("Inventory didn't match %v", inventory)
+	}
+	if _, ok := expanded["project"].(map[string]interface{})["error"]; !ok {
+		t.Errorf("Project should have failed %v", expanded["project"])
+	}
+	if v, ok := expanded["credentials"]; !ok || v != nil {
+		t.Errorf("Missing related link should be null %v", expanded["credentials"])
+	}
+}
+
+func TestExpandWithFilter(t *testing.T) {
+	transport := &routeTransport{routes: map[string]string{
+		"/api/v2/job_templates/": `{"count": 2, "previous": null, "next": null, "results": [
+			{"id": 7, "related": {"inventory": "/api/v2/inventories/5/"}},
+			{"id": 8, "related": {"inventory": "/api/v2/inventories/5/"}}]}`,
+		"/api/v2/inventories/5/": `{"id": 5, "name": "inv1"}`,
+	}}
+	config := &CatalogConfig{URL: "https://192.1.1.1", Token: "123", Expanded: &expandCache{}}
+	channel := make(chan ResponsePayload, 2)
+	jp := JobParam{
+		Method:      "get",
+		HrefSlug:    "/api/v2/job_templates/",
+		ApplyFilter: "results[].{id:id, inventory:expanded.inventory.name}",
+		Expand:      []string{"inventory"},
+	}
+	apiw := &DefaultAPIWorker{}
+	for i := 0; i < 2; i++ {
+		err := apiw.StartWork(context.Background(), config, jp, &http.Client{Transport: transport}, channel)
+		if err != nil {
+			t.Fatalf("StartWork failed %v", err)
+		}
+		payload := <-channel
+		if !strings.Contains(payload.data.Body, `"results":[{"id":7,"inventory":"inv1"},{"id":8,"inventory":"inv1"}]`) {
+			t.Errorf("Filtered body didn't match %s", payload.data.Body)
+		}
+	}
+	expected := []string{"/api/v2/job_templates/", "/api/v2/inventories/5/", "/api/v2/job_templates/"}
+	if !reflect.DeepEqual(transport.requested, expected) {
+		t.Errorf("Inventory should be fetched once per request %v", transport.requested)
+	}
+}
+
+func TestBadExpandFilter(t *testing.T) {
+	jp := JobParam{
+		Method:       "get",
+		HrefSlug:     "/api/v2/job_templates/",
+		Expand:       []string{"inventory"},
+		ExpandFilter: map[string]interface{}{"inventory": "{id:id"},
+	}
+	responseBody := []string{"Should not be fetched"}
+	ts := &testScaffold{}
+	ts.runFail(t, jp, 200, responseBody, "expand_filter inventory")
+}
+
+func TestIDsOnly(t *testing.T) {
+	responseBody := []string{
+		`{"count": 3, "previous": null, "next": "/api/v2/job_templates/?page=2", "results": [{"id": 7, "name": "jt7", "modified": "2020-01-02T00:00:00Z"}, {"id": 3, "name": "jt3", "modified": "2020-01-01T00:00:00Z"}]}`,
+		`{"count": 3, "previous": "/api/v2/job_templates/?page=1", "next": null, "results": [{"id": 9, "name": "jt9", "modified": "2020-01-03T00:00:00Z"}]}`}
+	responses := []map[string]interface{}{
+		{
+			"count":   3,
+			"results": []interface{}{},
+		},
+	}
+	jp := JobParam{Method: "ids_only", HrefSlug: "/api/v2/job_templates/"}
+	ts := &testScaffold{}
+	ts.runSuccess(t, jp, 200, responseBody, responses)
+}
+
+func reconcileBody(t *testing.T, params map[string]interface{}) map[string]interface{} {
+	responseBody := []string{
+		`{"count": 3, "previous": null, "next": null, "results": [{"id": 1, "modified": "2020-01-01T00:00:00Z"}, {"id": 2, "modified": "2020-03-01T00:00:00Z"}, {"id": 4, "modified": "2020-01-01T00:00:00Z"}]}`}
+	channel := make(chan ResponsePayload, 1)
+	w := &WorkUnit{outputChannel: channel}
+	w.setConfig(&CatalogConfig{URL: "https://192.1.1.1", Token: "123"})
+	w.setClient(fakeClient(t, responseBody, 200))
+	err := w.setJobParameters(JobParam{Method: "reconcile", HrefSlug: "/api/v2/job_templates/", Params: params})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.setURL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.dispatch()
+	if err != nil {
+		t.Fatalf("reconcile failed %v", err)
+	}
+	payload := <-channel
+	var body map[string]interface{}
+	err = json.Unmarshal([]byte(payload.data.Body), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func TestReconcileKnownModified(t *testing.T) {
+	body := reconcileBody(t, map[string]interface{}{
+		"known_ids": map[string]interface{}{"1": "2020-01-01T00:00:00Z", "2": "2020-01-01T00:00:00Z", "3": "2020-01-01T00:00:00Z"}})
+	expected := map[string]interface{}{
+		"count":   float64(3),
+		"added":   []interface{}{float64(4)},
+		"changed": []interface{}{float64(2)},
+		"removed": []interface{}{float64(3)},
+	}
+	if !reflect.DeepEqual(body, expected) {
+		t.Errorf("Reconcile didn't match %v", body)
+	}
+}
+
+func TestReconcileModifiedSince(t *testing.T) {
+	body := reconcileBody(t, map[string]interface{}{
+		"known_ids":      []interface{}{json.Number("1"), json.Number("2"), json.Number("5")},
+		"modified_since": "2020-02-01T00:00:00Z"})
+	expected := map[string]interface{}{
+		"count":   float64(3),
+		"added":   []interface{}{float64(4)},
+		"changed": []interface{}{float64(2)},
+		"removed": []interface{}{float64(5)},
+	}
+	if !reflect.DeepEqual(body, expected) {
+		t.Errorf("Reconcile didn't match %v", body)
+	}
+}
+
+func TestReconcileMissingKnownIDs(t *testing.T) {
+	jp := JobParam{Method: "reconcile", HrefSlug: "/api/v2/job_templates/"}
+	responseBody := []string{"Should not be fetched"}
+	ts := &testScaffold{}
+	ts.runFail(t, jp, 200, responseBody, "reconcile requires known_ids")
+}
+
+func TestGetContentHash(t *testing.T) {
+	responseBody := []string{`{"count": 2, "previous": null, "next": null, "results": [{"id": 1, "name": "jt1"}, {"id": 2, "name": "jt2"}]}`}
+	responses := []map[string]interface{}{
+		{
+			"count":         2,
+			"results":       []interface{}{},
+			"unchanged_ids": []interface{}{},
+		},
+	}
+	jp := JobParam{Method: "get", HrefSlug: "/api/v2/job_templates/", KnownHashes: map[string]string{"1": "sha256:old"}}
+	ts := &testScaffold{}
+	ts.runSuccess(t, jp, 200, responseBody, responses)
+}
+
+func TestHashObjectsUnchanged(t *testing.T) {
+	sum, err := contenthash.Sum(map[string]interface{}{"id": json.Number("1"), "name": "jt1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := &WorkUnit{input: &JobParam{KnownHashes: map[string]string{"1": sum, "2": "sha256:old"}}}
+	jsonBody, err := w.hashObjects(map[string]interface{}{"count": 2, "results": []interface{}{
+		map[string]interface{}{"id": json.Number("1"), "name": "jt1"},
+		map[string]interface{}{"id": json.Number("2"), "name": "jt2"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := jsonBody["results"].([]interface{})
+	if len(results) != 1 || results[0].(map[string]interface{})["id"] != json.Number("2") {
+		t.Errorf("Only the changed object should be sent %v", results)
+	}
+	if _, ok := results[0].(map[string]interface{})["content_hash"]; !ok {
+		t.Errorf("Missing content_hash %v", results[0])
+	}
+	if !reflect.DeepEqual(jsonBody["unchanged_ids"], []interface{}{json.Number("1")}) {
+		t.Errorf("Unchanged ids didn't match %v", jsonBody["unchanged_ids"])
+	}
+}
+
+// conditionalTransport returns the body with an ETag and then a 304
+// when the request has a matching If-None-Match
+type conditionalTransport struct {
+	requests int
+}
+
+func (c *conditionalTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.requests++
+	resp := &http.Response{
+		StatusCode: 200,
+		Status:     "200 OK",
+		Body:       ioutil.NopCloser(strings.NewReader(`{"count": 1, "previous": null, "next": null, "results": [{"id": 1}]}`)),
+		Header:     http.Header{"Etag": {`"v1"`}},
+	}
+	if req.Header.Get("If-None-Match") == `"v1"` {
+		resp.StatusCode = http.StatusNotModified
+		resp.Status = "304 Not Modified"
+		resp.Body = ioutil.NopCloser(strings.NewReader(""))
+	}
+	return resp, nil
+}
+
+func TestGetCached(t *testing.T) {
+	dir, err := ioutil.TempDir("", "catalog_worker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cache, err := httpcache.New(dir, httpcache.DefaultMaxBytes, httpcache.DefaultMaxEntryBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &CatalogConfig{URL: "https://192.1.1.1", Token: "123", Cache: cache}
+	transport := &conditionalTransport{}
+	client := &http.Client{Transport: transport}
+
+	for i := 0; i < 2; i++ {
+		channel := make(chan ResponsePayload, 1)
+		apiw := &DefaultAPIWorker{}
+		err = apiw.StartWork(context.Background(), config, JobParam{Method: "get", HrefSlug: "/api/v2/job_templates/"}, client, channel)
+		if err != nil {
+			t.Fatalf("StartWork failed %v", err)
+		}
+		payload := <-channel
+		if payload.code != 0 || payload.data.Status != 200 || !strings.Contains(payload.data.Body, `"results"`) {
+			t.Errorf("Response %d didn't match %v", i+1, payload)
+		}
+	}
+	if transport.requests != 2 {
+		t.Errorf("Expected 2 requests, got %d", transport.requests)
+	}
+}
+
+// routeTransport serves a body for each path and a 404 for the rest
+type routeTransport struct {
+	routes    map[string]string
+	requested []string
+}
+
+func (r *routeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r.requested = append(r.requested, req.URL.Path)
+	body, ok := r.routes[req.URL.Path]
+	resp := &http.Response{StatusCode: 200, Status: "200 OK", Body: ioutil.NopCloser(strings.NewReader(body))}
+	if !ok {
+		resp.StatusCode = 404
+		resp.Status = "404 Not Found"
+		resp.Body = ioutil.NopCloser(strings.NewReader(`{"detail": "Not found."}`))
+	}
+	return resp, nil
+}
+
+func TestGetControllerAPIRoot(t *testing.T) {
+	client := &http.Client{Transport: &routeTransport{routes: map[string]string{
+		"/api/":            `{"apis": {"gateway": "/api/gateway/", "controller": "/api/controller/"}}`,
+		"/api/controller/": `{"current_version": "/api/controller/v2/", "available_versions": {"v2": "/api/controller/v2/"}}`,
+		"/api/controller/v2/job_templates/": `{"count": 1, "previous": null, "next": "/api/controller/v2/job_templates/?page=2",
+			"results": [{"id": 1, "url": "/api/controller/v2/job_templates/1/", "related": {"inventory": "/api/controller/v2/inventories/5/"},
+			"description": "/api/controller/v2/ is the new API", "extra_vars": "{\"path\": \"/api/controller/v2/hosts/\"}"}]}`,
+	}}}
+	config := &CatalogConfig{URL: "https://192.1.1.1", Token: "123", API: &apiRoot{}}
+	channel := make(chan ResponsePayload, 1)
+	apiw := &DefaultAPIWorker{}
+	err := apiw.StartWork(context.Background(), config, JobParam{Method: "get", HrefSlug: "/api/v2/job_templates/"}, client, channel)
+	if err != nil {
+		t.Fatalf("StartWork failed %v", err)
+	}
+	payload := <-channel
+	if payload.code != 0 || payload.data.HrefSlug != "/api/v2/job_templates/" {
+		t.Fatalf("Response didn't match %v", payload)
+	}
+	var body map[string]interface{}
+	err = json.Unmarshal([]byte(payload.data.Body), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body["next"] != "/api/v2/job_templates/?page=2" {
+		t.Errorf("Next link has not been rewritten %v", body["next"])
+	}
+	result := body["results"].([]interface{})[0].(map[string]interface{})
+	if result["url"] != "/api/v2/job_templates/1/" || result["related"].(map[string]interface{})["inventory"] != "/api/v2/inventories/5/" {
+		t.Errorf("Links have not been rewritten %v", result)
+	}
+	if result["description"] != "/api/controller/v2/ is the new API" || result["extra_vars"] != `{"path": "/api/controller/v2/hosts/"}` {
+		t.Errorf("Strings that are not links should not be rewritten %v", result)
+	}
+}
+
+func TestDiscoverAPIRootFallback(t *testing.T) {
+	client := &http.Client{Transport: &routeTransport{routes: map[string]string{}}}
+	a := &apiRoot{}
+	prefix := a.discover(context.Background(), &CatalogConfig{URL: "https://192.1.1.1", Token: "123"}, client)
+	if prefix != defaultAPIPrefix {
+		t.Errorf("Expected the default prefix, got %s", prefix)
+	}
+}
+
+func towerRoutes() map[string]string {
+	return map[string]string{
+		"/api/":                `{"current_version": "/api/v2/"}`,
+		"/api/v2/ping/":        `{"version": "3.7.3", "instance_groups": [{"name": "tower", "capacity": 10}, {"name": "east"}]}`,
+		"/api/v2/config/":      `{"version": "3.7.3", "license_info": {"license_type": "enterprise"}}`,
+		"/api/v2/inventories/": `{"count": 0, "previous": null, "next": null, "results": []}`,
+	}
+}
+
+func TestStartWorkMinVersion(t *testing.T) {
+	client := &http.Client{Transport: &routeTransport{routes: towerRoutes()}}
+	config := &CatalogConfig{URL: "https://192.1.1.1", Token: "123", API: &apiRoot{}, Tower: &towerInfo{}}
+	channel := make(chan ResponsePayload, 1)
+	apiw := &DefaultAPIWorker{}
+	err := apiw.StartWork(context.Background(), config, JobParam{Method: "get", HrefSlug: "/api/v2/inventories/", MinVersion: "3.7"}, client, channel)
+	if err != nil {
+		t.Fatalf("StartWork failed %v", err)
+	}
+	if payload := <-channel; payload.code != 0 {
+		t.Errorf("Response didn't match %v", payload)
+	}
+
+	err = apiw.StartWork(context.Background(), config, JobParam{Method: "get", HrefSlug: "/api/v2/inventories/", MinVersion: "3.8.0"}, client, channel)
+	if err == nil {
+		t.Fatalf("Job requiring a newer version should have failed")
+	}
+	payload := <-channel
+	if payload.code != 1 || !strings.Contains(payload.data.Body, "older than the min_version 3.8.0") {
+		t.Errorf("Error didn't match %v", payload)
+	}
+
+	summary := config.Tower.summary()
+	if summary["version"] != "3.7.3" || summary["license_type"] != "enterprise" ||
+		!reflect.DeepEqual(summary["instance_groups"], []string{"tower", "east"}) {
+		t.Errorf("Summary didn't match %v", summary)
+	}
+}
+
+func TestStartWorkDetectsOnce(t *testing.T) {
+	transport := &routeTransport{routes: towerRoutes()}
+	config := &CatalogConfig{URL: "https://192.1.1.1", Token: "123", Tower: &towerInfo{}}
+	channel := make(chan ResponsePayload, 1)
+	apiw := &DefaultAPIWorker{}
+	for i := 0; i < 2; i++ {
+		err := apiw.StartWork(context.Background(), config, JobParam{Method: "get", HrefSlug: "/api/v2/inventories/"}, &http.Client{Transport: transport}, channel)
+		if err != nil {
+			t.Fatalf("StartWork failed %v", err)
+		}
+		<-channel
+	}
+	expected := []string{"/api/v2/ping/", "/api/v2/config/", "/api/v2/inventories/", "/api/v2/inventories/"}
+	if !reflect.DeepEqual(transport.requested, expected) {
+		t.Errorf("Tower should be detected once %v", transport.requested)
+	}
+	if summary := config.Tower.summary(); summary["version"] != "3.7.3" {
+		t.Errorf("Summary should be reported without a min_version %v", summary)
+	}
+}
+
+func TestStartWorkMinVersionUnknown(t *testing.T) {
+	client := &http.Client{Transport: &routeTransport{routes: map[string]string{}}}
+	config := &CatalogConfig{URL: "https://192.1.1.1", Token: "123", Tower: &towerInfo{}}
+	channel := make(chan ResponsePayload, 1)
+	apiw := &DefaultAPIWorker{}
+	err := apiw.StartWork(context.Background(), config, JobParam{Method: "get", HrefSlug: "/api/v2/inventories/", MinVersion: "3.7"}, client, channel)
+	if err == nil {
+		t.Fatalf("Job should have failed without a version")
+	}
+	if payload := <-channel; !strings.Contains(payload.data.Body, "Unable to determine the Ansible Tower version") {
+		t.Errorf("Error didn't match %v", payload)
+	}
+}
+
+func pingReport(t *testing.T, routes map[string]string) map[string]interface{} {
+	client := &http.Client{Transport: &routeTransport{routes: routes}}
+	config := &CatalogConfig{URL: "https://192.1.1.1", Token: "123"}
+	channel := make(chan ResponsePayload, 1)
+	apiw := &DefaultAPIWorker{}
+	err := apiw.StartWork(context.Background(), config, JobParam{Method: "ping"}, client, channel)
+	if err != nil {
+		t.Fatalf("StartWork failed %v", err)
+	}
+	payload := <-channel
+	if payload.code != 0 || payload.data.HrefSlug != pingSlug {
+		t.Fatalf("Response didn't match %v", payload)
+	}
+	var report map[string]interface{}
+	err = json.Unmarshal([]byte(payload.data.Body), &report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func TestPingControllerAPI(t *testing.T) {
+	transport := &routeTransport{routes: map[string]string{
+		"/api/":                    `{"apis": {"gateway": "/api/gateway/", "controller": "/api/controller/"}}`,
+		"/api/controller/":         `{"current_version": "/api/controller/v2/"}`,
+		"/api/controller/v2/ping/": `{"version": "4.5.0"}`,
+		"/api/controller/v2/me/":   `{"count": 1, "results": [{"id": 1, "username": "admin", "is_superuser": true}]}`,
+		"/api/controller/v2/users/1/organizations/": `{"count": 1, "results": [{"id": 1, "name": "Default"}]}`,
+	}}
+	config := &CatalogConfig{URL: "https://192.1.1.1", Token: "123", API: &apiRoot{}, Tower: &towerInfo{}}
+	channel := make(chan ResponsePayload, 1)
+	apiw := &DefaultAPIWorker{}
+	err := apiw.StartWork(context.Background(), config, JobParam{Method: "ping"}, &http.Client{Transport: transport}, channel)
+	if err != nil {
+		t.Fatalf("StartWork failed %v", err)
+	}
+	var report map[string]interface{}
+	err = json.Unmarshal([]byte((<-channel).data.Body), &report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report["ok"] != true || report["api_prefix"] != "/api/controller/v2/" {
+		t.Errorf("Ping should pass with the controller API %v", report)
+	}
+	for _, path := range transport.requested {
+		if path == "/api/v2/config/" {
+			t.Errorf("Ping should not detect the Tower version %v", transport.requested)
+		}
+	}
+}
+
+func TestPing(t *testing.T) {
+	routes := towerRoutes()
+	routes["/api/v2/me/"] = `{"count": 1, "results": [{"id": 1, "username": "admin", "is_superuser": false, "is_system_auditor": true}]}`
+	routes["/api/v2/users/1/organizations/"] = `{"count": 1, "results": [{"id": 1, "name": "Default"}]}`
+	report := pingReport(t, routes)
+	if report["ok"] != true {
+		t.Errorf("Ping should have passed %v", report)
+	}
+	checks := report["checks"].(map[string]interface{})
+	connectivity := checks["connectivity"].(map[string]interface{})
+	if connectivity["version"] != "3.7.3" {
+		t.Errorf("Connectivity didn't match %v", connectivity)
+	}
+	if _, ok := connectivity["latency_ms"]; !ok {
+		t.Errorf("Missing latency %v", connectivity)
+	}
+	permissions := checks["permissions"].(map[string]interface{})
+	if permissions["role"] != "auditor" || permissions["username"] != "admin" ||
+		!reflect.DeepEqual(permissions["organizations"], []interface{}{"Default"}) {
+		t.Errorf("Permissions didn't match %v", permissions)
+	}
+}
+
+func TestPingTokenFailed(t *testing.T) {
+	report := pingReport(t, towerRoutes())
+	if report["ok"] != false {
+		t.Errorf("Ping should have failed %v", report)
+	}
+	checks := report["checks"].(map[string]interface{})
+	if checks["connectivity"].(map[string]interface{})["ok"] != true {
+		t.Errorf("Connectivity should have passed %v", checks)
+	}
+	if checks["token"].(map[string]interface{})["ok"] != false {
+		t.Errorf("Token should have failed %v", checks)
+	}
+	if _, ok := checks["permissions"]; ok {
+		t.Errorf("Permissions should have been skipped %v", checks)
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"3.7.3", "3.7", 1},
+		{"3.7.0", "3.7", 0},
+		{"3.6.9", "3.7.0", -1},
+		{"19.2.0", "3.8", 1},
+		{"3.8.1-1", "3.8.1", 0},
+	}
+	for _, tc := range tests {
+		if got := compareVersions(tc.a, tc.b); got != tc.want {
+			t.Errorf("compareVersions(%s, %s) = %d, want %d", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
 func TestPost(t *testing.T) {
 	responseBody := []string{`{"name": "job1", "id": 1, "artifacts":{"expose_to_redhat_com_name": "Fred"}}`}
 	responses := []map[string]interface{}{
@@ -100,6 +934,131 @@ func TestPost(t *testing.T) {
 	ts.runSuccess(t, jp, 200, responseBody, responses)
 }
 
+func TestBadFilter(t *testing.T) {
+	jp := JobParam{
+		Method:      "get",
+		HrefSlug:    "/api/v2/job_templates",
+		ApplyFilter: "results[].{id:id",
+	}
+	responseBody := []string{"Should not be fetched"}
+	ts := &testScaffold{}
+	ts.runFail(t, jp, 200, responseBody, "Invalid filter expression")
+}
+
+func TestFilterTypeMismatch(t *testing.T) {
+	jp := JobParam{
+		Method:      "get",
+		HrefSlug:    "/api/v2/jobs/15",
+		ApplyFilter: "{id:id}",
+	}
+	responseBody := []string{`{"name": "job15", "id": 15, "url": "url15"}`}
+	ts := &testScaffold{}
+	ts.runFail(t, jp, 200, responseBody, "returned an object, expected a list")
+}
+
+func TestPostArtifactsDropped(t *testing.T) {
+	responseBody := []string{`{"name": "job1", "id": 1, "status": "successful", "artifacts":{"expose_to_cloud_redhat_com_name": "` + strings.Repeat("na", 1024) + `"}}`}
+	responses := []map[string]interface{}{
+		{
+			"status":           "successful",
+			"artifacts":        map[string]interface{}{},
+			"artifacts_report": map[string]interface{}{},
+		},
+	}
+
+	jp := JobParam{
+		Method:   "post",
+		HrefSlug: "/api/v2/job_templates/5/launch",
+	}
+	ts := &testScaffold{}
+	ts.base(t, jp, 200, responseBody)
+	ts.responses = responses
+	ts.config.Artifacts = artifacts.DefaultRules()
+	ts.config.Artifacts.Policy = artifacts.PolicyDrop
+	apiw := &DefaultAPIWorker{}
+	err := apiw.StartWork(context.Background(), ts.config, jp, ts.client, ts.outputChannel)
+	if err != nil {
+		t.Fatalf("StartWork failed %v", err)
+	}
+	ts.checkWorkResponse()
+}
+
+func TestPostArtifactsTooLarge(t *testing.T) {
+	responseBody := []string{`{"name": "job1", "id": 1, "artifacts":{"expose_to_cloud_redhat_com_name": "` + strings.Repeat("na", 1024) + `"}}`}
+	jp := JobParam{
+		Method:   "post",
+		HrefSlug: "/api/v2/job_templates/5/launch",
+	}
+	ts := &testScaffold{}
+	ts.runFail(t, jp, 200, responseBody, "Artifacts is greater than 1024 bytes")
+}
+
+func TestGetArtifactsInResults(t *testing.T) {
+	responseBody := []string{`{"count": 2, "previous": null, "next": null, "results": [{"id": 1, "artifacts": "not a map"}, {"id": 2, "artifacts": {"abc": "123"}}]}`}
+	responses := []map[string]interface{}{
+		{
+			"count":   2,
+			"results": []interface{}{},
+		},
+	}
+	jp := JobParam{
+		Method:   "get",
+		HrefSlug: "/api/v2/jobs",
+	}
+	ts := &testScaffold{}
+	ts.runSuccess(t, jp, 200, responseBody, responses)
+}
+
+func TestGetRedaction(t *testing.T) {
+	responseBody := []string{`{"name": "job15", "id": 15, "status": "successful", "extra_vars": "{\"db_password\": \"hunter2\"}", "artifacts": {"expose_to_cloud_redhat_com_token": "abc"}}`}
+	responses := []map[string]interface{}{
+		{
+			"extra_vars": `{"db_password":"$encrypted$"}`,
+			"redactions": 2,
+		},
+	}
+	jp := JobParam{
+		Method:   "get",
+		HrefSlug: "/api/v2/jobs/15",
+	}
+	ts := &testScaffold{}
+	ts.runSuccess(t, jp, 200, responseBody, responses)
+}
+
+func TestGetArtifactsSchema(t *testing.T) {
+	responseBody := []string{`{"name": "job15", "id": 15, "status": "successful", "artifacts": {"expose_to_cloud_redhat_com_vm_ip": "10.0.0.1", "expose_to_cloud_redhat_com_cpus": "two"}}`}
+	responses := []map[string]interface{}{
+		{
+			"artifacts":        map[string]interface{}{},
+			"artifacts_errors": map[string]interface{}{},
+		},
+	}
+	jp := JobParam{
+		Method:   "get",
+		HrefSlug: "/api/v2/jobs/15",
+		ArtifactsSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"expose_to_cloud_redhat_com_vm_ip": map[string]interface{}{"type": "string", "format": "ipv4"},
+				"expose_to_cloud_redhat_com_cpus":  map[string]interface{}{"type": "integer"},
+			},
+		},
+	}
+	ts := &testScaffold{}
+	ts.runSuccess(t, jp, 200, responseBody, responses)
+}
+
+func TestBadArtifactsSchema(t *testing.T) {
+	jp := JobParam{
+		Method:          "get",
+		HrefSlug:        "/api/v2/jobs/15",
+		ArtifactsSchema: map[string]interface{}{"type": "string"},
+	}
+	responseBody := []string{"Should not be fetched"}
+	ts := &testScaffold{}
+	ts.runFail(t, jp, 200, responseBody, "Invalid artifacts schema")
+}
+
 func TestUnknownMethod(t *testing.T) {
 	jp := JobParam{
 		Method:   "unknown",